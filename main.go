@@ -2,27 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync/atomic"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/valkey-io/valkey-go"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/leaderelection"
-	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/sapslaj/valkey-leader/pkg/env"
+	"github.com/sapslaj/valkey-leader/pkg/leader"
 )
 
 func main() {
-	var leading atomic.Bool
-	leading.Store(false)
-
 	mainLogger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: true,
 	}))
@@ -38,15 +43,52 @@ func main() {
 	namespace := env.MustGet[string]("NAMESPACE")
 	podIP := env.MustGet[string]("POD_IP")
 	podName := env.MustGet[string]("POD_NAME")
+	podUID := env.MustGetDefault("POD_UID", "")
 	serviceName := env.MustGet[string]("SERVICE_NAME")
 	leaderLeaseName := env.MustGetDefault("LEADER_LEASE_NAME", clusterName)
 	reconcileInterval := env.MustGetDefault("RECONCILE_INTERVAL", 5*time.Second)
 	leaseDuration := env.MustGetDefault("LEASE_DURATION", 5*time.Second)
 	renewDeadline := env.MustGetDefault("RENEW_DEADLINE", 4*time.Second)
 	retryPeriod := env.MustGetDefault("RETRY_PERIOD", 2*time.Second)
+	promotionLagTolerance := env.MustGetDefault("PROMOTION_LAG_TOLERANCE", int64(0))
+	switchoverTimeout := env.MustGetDefault("SWITCHOVER_TIMEOUT", 10*time.Second)
+	fenceTimeout := env.MustGetDefault("FENCE_TIMEOUT", 30*time.Second)
+	replicationLinkTimeout := env.MustGetDefault("REPLICATION_LINK_TIMEOUT", 30*time.Second)
+	replicationAddressMode := env.MustGetDefault("REPLICATION_ADDRESS_MODE", leader.ReplicationAddressIP)
+	apiTimeout := env.MustGetDefault("API_TIMEOUT", leader.DefaultAPITimeout)
+	watchdogTimeout := env.MustGetDefault("WATCHDOG_TIMEOUT", time.Minute)
+	unhealthyThreshold := env.MustGetDefault("UNHEALTHY_THRESHOLD", 3)
+	httpAddress := env.MustGetDefault("HTTP_ADDRESS", ":8080")
 	valkeyAddress := env.MustGetDefault("VALKEY_ADDRESS", "localhost:6379")
+	valkeyAddressHost, valkeyAddressPort, err := net.SplitHostPort(valkeyAddress)
+	if err != nil {
+		slog.Error("error parsing VALKEY_ADDRESS", slog.Any("error", err))
+		os.Exit(1)
+	}
+	defaultValkeyPort, err := strconv.ParseInt(valkeyAddressPort, 10, 32)
+	if err != nil {
+		slog.Error("error parsing VALKEY_ADDRESS port", slog.Any("error", err))
+		os.Exit(1)
+	}
+	valkeyPort := env.MustGetDefault("VALKEY_PORT", defaultValkeyPort)
+	valkeyDialTimeout := env.MustGetDefault("VALKEY_DIAL_TIMEOUT", 5*time.Second)
+	valkeyCommandTimeout := env.MustGetDefault("VALKEY_COMMAND_TIMEOUT", 5*time.Second)
+	valkeyHealthCheckInterval := env.MustGetDefault("VALKEY_HEALTH_CHECK_INTERVAL", 5*time.Second)
 	valkeyUsername := env.MustGetDefault("VALKEY_USERNAME", "")
 	valkeyPassword := env.MustGetDefault("VALKEY_PASSWORD", "")
+	valkeyUsernameFile := env.MustGetDefault("VALKEY_USERNAME_FILE", "")
+	valkeyPasswordFile := env.MustGetDefault("VALKEY_PASSWORD_FILE", "")
+	valkeyReplicationUsername := env.MustGetDefault("VALKEY_REPLICATION_USERNAME", "")
+	valkeyReplicationPassword := env.MustGetDefault("VALKEY_REPLICATION_PASSWORD", "")
+	valkeyReplicationUsernameFile := env.MustGetDefault("VALKEY_REPLICATION_USERNAME_FILE", "")
+	valkeyReplicationPasswordFile := env.MustGetDefault("VALKEY_REPLICATION_PASSWORD_FILE", "")
+	valkeyTLSEnabled := env.MustGetDefault("VALKEY_TLS_ENABLED", false)
+	valkeyTLSFiles := leader.TLSFiles{
+		CAFile:     env.MustGetDefault("VALKEY_TLS_CA_FILE", ""),
+		CertFile:   env.MustGetDefault("VALKEY_TLS_CERT_FILE", ""),
+		KeyFile:    env.MustGetDefault("VALKEY_TLS_KEY_FILE", ""),
+		ServerName: env.MustGetDefault("VALKEY_TLS_SERVER_NAME", ""),
+	}
 
 	mainLogger = mainLogger.With(
 		slog.String("cluster_name", clusterName),
@@ -57,192 +99,145 @@ func main() {
 		slog.String("leader_lease_name", leaderLeaseName),
 	)
 
+	if replicationAddressMode != leader.ReplicationAddressIP && replicationAddressMode != leader.ReplicationAddressDNS {
+		mainLogger.Error("invalid REPLICATION_ADDRESS_MODE, expected ip or dns", slog.String("replication_address_mode", replicationAddressMode))
+		os.Exit(1)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Add cluster label to current pod
-	pod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	valkeyCredentials := leader.FileCredentials(valkeyUsernameFile, valkeyPasswordFile, leader.Credentials{
+		Username: valkeyUsername,
+		Password: valkeyPassword,
+	})
+	// Replicas authenticate to the primary as the sidecar does unless
+	// separate replication credentials are configured.
+	valkeyReplicationCredentials := valkeyCredentials
+	if valkeyReplicationUsername != "" || valkeyReplicationPassword != "" || valkeyReplicationUsernameFile != "" || valkeyReplicationPasswordFile != "" {
+		valkeyReplicationCredentials = leader.FileCredentials(valkeyReplicationUsernameFile, valkeyReplicationPasswordFile, leader.Credentials{
+			Username: valkeyReplicationUsername,
+			Password: valkeyReplicationPassword,
+		})
+	}
+	_, err = valkeyCredentials()
 	if err != nil {
-		mainLogger.Error("failed to get current pod", slog.Any("error", err))
+		mainLogger.Error("error loading Valkey credentials", slog.Any("error", err))
 		os.Exit(1)
 	}
-
-	if pod.Labels == nil {
-		pod.Labels = make(map[string]string)
-	}
-	pod.Labels["valkey.sapslaj.cloud/cluster"] = clusterName
-
-	_, err = client.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{})
+	_, err = valkeyReplicationCredentials()
 	if err != nil {
-		mainLogger.Error("failed to update pod labels", slog.Any("error", err))
+		mainLogger.Error("error loading Valkey replication credentials", slog.Any("error", err))
 		os.Exit(1)
 	}
 
+	valkeyOption := valkey.ClientOption{
+		InitAddress: []string{valkeyAddress},
+		Dialer: net.Dialer{
+			Timeout: valkeyDialTimeout,
+		},
+	}
+	var valkeyTLSConfigs leader.TLSConfigs
+	if valkeyTLSEnabled || valkeyTLSFiles != (leader.TLSFiles{}) {
+		valkeyTLSConfigs, err = leader.NewTLSConfigs(valkeyTLSFiles)
+		if err != nil {
+			mainLogger.Error("error loading Valkey TLS configuration", slog.Any("error", err))
+			os.Exit(1)
+		}
+		valkeyOption.TLSConfig = valkeyTLSConfigs(valkeyAddressHost)
+	}
+	valkeyClient := leader.NewValkeyClient(valkeyOption)
+	valkeyClient.TLSConfigs = valkeyTLSConfigs
+	valkeyClient.Credentials = valkeyCredentials
+	valkeyClient.ReplicationCredentials = valkeyReplicationCredentials
+	valkeyClient.Logger = mainLogger
+	valkeyClient.CommandTimeout = valkeyCommandTimeout
+	defer valkeyClient.Close()
+	go valkeyClient.RunHealthChecks(ctx, valkeyHealthCheckInterval)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metrics := leader.NewMetrics(registry)
+	leaderelection.SetProvider(metrics)
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(namespace),
+	})
+	defer broadcaster.Shutdown()
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: "valkey-leader",
+	})
+
+	controller := leader.NewController(leader.Config{
+		ClusterName:            clusterName,
+		Namespace:              namespace,
+		PodIP:                  podIP,
+		PodName:                podName,
+		PodUID:                 podUID,
+		ServiceName:            serviceName,
+		ValkeyPort:             valkeyPort,
+		LeaderLeaseName:        leaderLeaseName,
+		ReconcileInterval:      reconcileInterval,
+		LeaseDuration:          leaseDuration,
+		RenewDeadline:          renewDeadline,
+		RetryPeriod:            retryPeriod,
+		PromotionLagTolerance:  promotionLagTolerance,
+		SwitchoverTimeout:      switchoverTimeout,
+		FenceTimeout:           fenceTimeout,
+		ReplicationLinkTimeout: replicationLinkTimeout,
+		ReplicationAddressMode: replicationAddressMode,
+		APITimeout:             apiTimeout,
+		WatchdogTimeout:        watchdogTimeout,
+		UnhealthyThreshold:     unhealthyThreshold,
+		Metrics:                metrics,
+		Recorder:               recorder,
+		Logger:                 mainLogger,
+	}, client, valkeyClient)
+
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-ch
 		mainLogger.Info("received termination, signaling shutdown")
-		leading.Store(false)
+		err := controller.Shutdown(ctx)
+		if err != nil {
+			mainLogger.Warn("releasing lease without switchover", slog.Any("error", err))
+		}
 		cancel()
 	}()
 
-	makeValkeyClient := func() (valkey.Client, error) {
-		return valkey.NewClient(valkey.ClientOption{
-			InitAddress: []string{valkeyAddress},
-			Username:    valkeyUsername,
-			Password:    valkeyPassword,
-		})
-
+	server := &http.Server{
+		Addr:              httpAddress,
+		Handler:           controller.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
 	}
-
+	serverErr := make(chan error, 1)
 	go func() {
-		for {
-			logger := mainLogger.With()
-			select {
-			case <-time.After(reconcileInterval):
-				if leading.Load() {
-					continue
-				}
-
-				pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-					LabelSelector: "valkey.sapslaj.cloud/instance-role=primary",
-				})
-				if err != nil {
-					logger.Error("failed to list pods", slog.Any("error", err))
-					continue
-				}
-
-				if len(pods.Items) == 0 {
-					logger.Warn("no primary pod found, retrying")
-					continue
-				}
-
-				primaryPod := pods.Items[0]
-				primaryIP := primaryPod.Status.PodIP
-				if primaryIP == "" {
-					logger.Warn("primary pod has no IP address, retrying")
-					continue
-				}
-
-				logger.Info("found primary pod", slog.String("primary_pod", primaryPod.Name), slog.String("primary_ip", primaryIP))
-
-				// Connect to local Valkey and configure replication
-				valkeyClient, err := makeValkeyClient()
-				if err != nil {
-					logger.Error("failed to create Valkey client", slog.Any("error", err))
-					continue
-				}
-
-				err = valkeyClient.Do(ctx, valkeyClient.B().Replicaof().Host(primaryIP).Port(6379).Build()).Error()
-				valkeyClient.Close()
-				if err != nil {
-					logger.Error("failed to configure replication", slog.Any("error", err))
-					continue
-				}
-
-				logger.Info("configured replication", slog.String("primary_ip", primaryIP))
-
-				// Add replica label to current pod
-				currentPod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
-				if err != nil {
-					logger.Error("failed to get current pod", slog.Any("error", err))
-					continue
-				}
-
-				if currentPod.Labels == nil {
-					currentPod.Labels = make(map[string]string)
-				}
-				currentPod.Labels["valkey.sapslaj.cloud/instance-role"] = "replica"
-
-				_, err = client.CoreV1().Pods(namespace).Update(ctx, currentPod, metav1.UpdateOptions{})
-				if err != nil {
-					logger.Error("failed to update pod labels", slog.Any("error", err))
-					continue
-				}
-
-				logger.Info("updated pod with replica label")
-
-			case <-ctx.Done():
-				logger.InfoContext(ctx, "context canceled")
-				return
-			}
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			mainLogger.Error("HTTP server failed", slog.Any("error", err), slog.String("http_address", httpAddress))
+			serverErr <- err
+			cancel()
 		}
 	}()
 
-	lock := &resourcelock.LeaseLock{
-		LeaseMeta: metav1.ObjectMeta{
-			Name:      leaderLeaseName,
-			Namespace: namespace,
-		},
-		Client: client.CoordinationV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity: podIP,
-		},
-	}
+	err = controller.Run(ctx)
 
-	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
-		Lock:            lock,
-		ReleaseOnCancel: true,
-		LeaseDuration:   leaseDuration,
-		RenewDeadline:   renewDeadline,
-		RetryPeriod:     retryPeriod,
-		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: func(ctx context.Context) {
-				leading.Store(true)
-				for leading.Load() {
-					logger := mainLogger.With()
-					select {
-					case <-time.After(reconcileInterval):
-
-						valkeyClient, err := makeValkeyClient()
-						if err != nil {
-							logger.Error("failed to create Valkey client", slog.Any("error", err))
-							continue
-						}
-
-						err = valkeyClient.Do(ctx, valkeyClient.B().Replicaof().No().One().Build()).Error()
-						valkeyClient.Close()
-						if err != nil {
-							logger.Error("failed to promote to primary", slog.Any("error", err))
-							continue
-						}
-
-						logger.Info("promoted to primary")
-
-						currentPod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
-						if err != nil {
-							logger.Error("failed to get current pod", slog.Any("error", err))
-							continue
-						}
-
-						if currentPod.Labels == nil {
-							currentPod.Labels = make(map[string]string)
-						}
-						currentPod.Labels["valkey.sapslaj.cloud/instance-role"] = "primary"
-
-						_, err = client.CoreV1().Pods(namespace).Update(ctx, currentPod, metav1.UpdateOptions{})
-						if err != nil {
-							logger.Error("failed to update pod labels", slog.Any("error", err))
-							continue
-						}
-
-						logger.Info("updated pod with primary label")
-
-					case <-ctx.Done():
-						logger.InfoContext(ctx, "context canceled")
-						return
-					}
-				}
-			},
-			OnStoppedLeading: func() {
-				mainLogger.Info("leader lost")
-				leading.Store(false)
-			},
-			OnNewLeader: func(identity string) {
-				mainLogger.Info("new leader elected", slog.String("identity", identity), slog.Bool("self", identity == podIP))
-			},
-		},
-	})
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	_ = server.Shutdown(shutdownCtx)
 
+	if err != nil {
+		mainLogger.Error("controller failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	select {
+	case <-serverErr:
+		os.Exit(1)
+	default:
+	}
 }
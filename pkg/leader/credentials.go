@@ -0,0 +1,92 @@
+package leader
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// Credentials is a Valkey ACL username and password. An empty Username means
+// the default user.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsFunc returns the credentials to use at the time it is called.
+type CredentialsFunc func() (Credentials, error)
+
+// StaticCredentials always returns credentials.
+func StaticCredentials(credentials Credentials) CredentialsFunc {
+	return func() (Credentials, error) {
+		return credentials, nil
+	}
+}
+
+// FileCredentials reads the username and password from usernameFile and
+// passwordFile on every call, so that rotated Secrets are picked up. Fields
+// whose file path is empty are taken from defaults instead.
+func FileCredentials(usernameFile string, passwordFile string, defaults Credentials) CredentialsFunc {
+	return func() (Credentials, error) {
+		credentials := defaults
+		var err error
+		if usernameFile != "" {
+			credentials.Username, err = readCredentialFile(usernameFile)
+			if err != nil {
+				return Credentials{}, err
+			}
+		}
+		if passwordFile != "" {
+			credentials.Password, err = readCredentialFile(passwordFile)
+			if err != nil {
+				return Credentials{}, err
+			}
+		}
+		return credentials, nil
+	}
+}
+
+// readCredentialFile reads a credential, dropping the trailing newline most
+// editors and `kubectl create secret --from-file` leave behind.
+func readCredentialFile(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
+
+// credentialRotation remembers the last two distinct credentials returned by
+// a CredentialsFunc, so that a client can fall back to the previous ones while
+// a rotation has reached the Secret but not yet Valkey's ACL, or vice versa.
+type credentialRotation struct {
+	mu       sync.Mutex
+	current  *Credentials
+	previous *Credentials
+}
+
+// candidates loads the current credentials and returns them followed by the
+// previous ones, if any. changed reports whether load returned credentials
+// different from the last call. If load fails after credentials have been
+// loaded before, the known credentials are returned instead of the error.
+func (r *credentialRotation) candidates(load CredentialsFunc) ([]Credentials, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, err := load()
+	if err != nil && r.current == nil {
+		return nil, false, err
+	}
+	changed := false
+	if err == nil && (r.current == nil || *r.current != loaded) {
+		changed = r.current != nil
+		r.previous = r.current
+		r.current = &loaded
+	}
+
+	candidates := []Credentials{*r.current}
+	if r.previous != nil {
+		candidates = append(candidates, *r.previous)
+	}
+	return candidates, changed, nil
+}
@@ -0,0 +1,72 @@
+package leader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCredentials(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	err := os.WriteFile(passwordFile, []byte("hunter2\n"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write password file: %v", err)
+	}
+
+	credentials := FileCredentials("", passwordFile, Credentials{Username: "replication"})
+	got, err := credentials()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Username != "replication" || got.Password != "hunter2" {
+		t.Errorf("expected replication/hunter2; got %s/%s", got.Username, got.Password)
+	}
+
+	err = os.WriteFile(passwordFile, []byte("correct horse"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write password file: %v", err)
+	}
+	got, err = credentials()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Password != "correct horse" {
+		t.Errorf("expected rotated password; got %s", got.Password)
+	}
+}
+
+func TestFileCredentialsMissingFile(t *testing.T) {
+	_, err := FileCredentials("", filepath.Join(t.TempDir(), "missing"), Credentials{})()
+	if err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
+
+func TestCredentialRotation(t *testing.T) {
+	var rotation credentialRotation
+	loaded := Credentials{Password: "old"}
+	var loadErr error
+	load := func() (Credentials, error) {
+		return loaded, loadErr
+	}
+
+	candidates, changed, err := rotation.candidates(load)
+	if err != nil || changed || len(candidates) != 1 || candidates[0].Password != "old" {
+		t.Fatalf("initial load: got %v, changed %v, error %v", candidates, changed, err)
+	}
+
+	loaded = Credentials{Password: "new"}
+	candidates, changed, err = rotation.candidates(load)
+	if err != nil || !changed || len(candidates) != 2 || candidates[0].Password != "new" || candidates[1].Password != "old" {
+		t.Fatalf("after rotation: got %v, changed %v, error %v", candidates, changed, err)
+	}
+
+	// A file that is briefly missing mid-rotation keeps the known credentials.
+	loadErr = errors.New("no such file or directory")
+	candidates, changed, err = rotation.candidates(load)
+	if err != nil || changed || len(candidates) != 2 || candidates[0].Password != "new" {
+		t.Fatalf("with load error: got %v, changed %v, error %v", candidates, changed, err)
+	}
+}
@@ -0,0 +1,80 @@
+package leader
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons of the Events recorded against the pod.
+const (
+	EventPromoted              = "Promoted"
+	EventDemoted               = "Demoted"
+	EventReplicationConfigured = "ReplicationConfigured"
+	EventLeaseLost             = "LeaseLost"
+	EventReconcileFailed       = "ReconcileFailed"
+	EventSteppedDown           = "SteppedDown"
+	EventEmptyDataset          = "EmptyDataset"
+)
+
+// event records an Event against this Controller's pod.
+func (c *Controller) event(eventType string, reason string, messageFmt string, args ...any) {
+	c.recorder.Eventf(c.podObject(), eventType, reason, messageFmt, args...)
+}
+
+// podObject returns this Controller's pod from the informer cache, or a bare
+// reference to it if the cache doesn't have it.
+func (c *Controller) podObject() runtime.Object {
+	pod, err := c.podLister.Pods(c.config.Namespace).Get(c.config.PodName)
+	if err == nil {
+		return pod
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  c.config.Namespace,
+		Name:       c.config.PodName,
+	}
+}
+
+// reconcileEvents records Events for what changed between two reconciles.
+// Failures are only recorded when the error changes so that a persistent
+// failure doesn't exhaust the recorder's rate limit for Warning events.
+func (c *Controller) reconcileEvents(role string, previous Status, previousSource string, current Status, err error) {
+	if err != nil {
+		if current.LastReconcileError == previous.LastReconcileError {
+			return
+		}
+		step := StepOther
+		var reconcileErr *ReconcileError
+		if errors.As(err, &reconcileErr) {
+			step = reconcileErr.Step
+		}
+		c.event(corev1.EventTypeWarning, EventReconcileFailed, "Reconcile as %s failed at step %s: %v", role, step, err)
+		return
+	}
+
+	switch current.Role {
+	case RolePrimary:
+		if previous.Role != RolePrimary {
+			c.event(corev1.EventTypeNormal, EventPromoted, "Promoted to primary at %s", c.config.PodIP)
+		}
+	case RoleReplica:
+		if previous.Role == RolePrimary {
+			c.event(corev1.EventTypeNormal, EventDemoted, "Demoted to replica of %s at %s", current.ObservedPrimary, current.ObservedPrimaryAddress)
+		}
+		if previous.Role != RoleReplica || previousSource != current.ObservedPrimaryAddress {
+			c.event(corev1.EventTypeNormal, EventReplicationConfigured, "Replicating from primary %s at %s", current.ObservedPrimary, current.ObservedPrimaryAddress)
+		}
+	}
+}
+
+func (c *Controller) leaseHolderDescription() string {
+	holder := c.Status().LeaseHolder
+	if holder == "" || holder == c.Identity() {
+		return "no new leader observed yet"
+	}
+	return fmt.Sprintf("new leader is %s", holder)
+}
@@ -0,0 +1,110 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func recordEvents(c *Controller) *record.FakeRecorder {
+	recorder := record.NewFakeRecorder(100)
+	c.recorder = recorder
+	return recorder
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func expectEvents(t *testing.T, recorder *record.FakeRecorder, prefixes ...string) {
+	t.Helper()
+
+	events := drainEvents(recorder)
+	if len(events) != len(prefixes) {
+		t.Fatalf("expected %d events; got %q", len(prefixes), events)
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(events[i], prefix) {
+			t.Errorf("event %d: expected prefix %q; got %q", i, prefix, events[i])
+		}
+	}
+}
+
+func TestReplicationConfiguredEvent(t *testing.T) {
+	c, client, _ := testController(t, "valkey-2", "10.0.0.3",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+		testPod("valkey-2", "10.0.0.3", nil),
+	)
+	recorder := recordEvents(c)
+
+	c.recordReconcile(RoleReplica, 0, c.ReconcileReplica(context.Background()))
+	expectEvents(t, recorder, "Normal ReplicationConfigured Replicating from primary valkey-0 at 10.0.0.1")
+
+	c.recordReconcile(RoleReplica, 0, c.ReconcileReplica(context.Background()))
+	expectEvents(t, recorder)
+
+	setLeaseHolder(t, client, "valkey-1")
+	eventually(t, time.Second, func() bool {
+		err := c.ReconcileReplica(context.Background())
+		return err == nil && c.Status().ObservedPrimary == "valkey-1"
+	})
+	c.recordReconcile(RoleReplica, 0, nil)
+	expectEvents(t, recorder, "Normal ReplicationConfigured Replicating from primary valkey-1 at 10.0.0.2")
+}
+
+func TestPromotedEvent(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.role = RoleReplica
+	recorder := recordEvents(c)
+
+	c.recordReconcile(RolePrimary, 0, c.ReconcilePrimary(context.Background()))
+	c.recordReconcile(RolePrimary, 0, c.ReconcilePrimary(context.Background()))
+	expectEvents(t, recorder, "Normal Promoted Promoted to primary at 10.0.0.1")
+}
+
+func TestReconcileFailedEvent(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	recorder := recordEvents(c)
+	fv.err = errors.New("connection refused")
+
+	c.recordReconcile(RolePrimary, 0, c.ReconcilePrimary(context.Background()))
+	c.recordReconcile(RolePrimary, 0, c.ReconcilePrimary(context.Background()))
+	expectEvents(t, recorder, "Warning ReconcileFailed Reconcile as primary failed at step promote: ")
+}
+
+func TestLeaseLostEvents(t *testing.T) {
+	c, _, _ := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+	)
+	recorder := recordEvents(c)
+	lead(t, c)
+	c.OnNewLeader("valkey-1")
+
+	c.OnStoppedLeading()
+
+	events := drainEvents(recorder)
+	if !slices.ContainsFunc(events, func(event string) bool {
+		return strings.HasPrefix(event, "Warning LeaseLost Lost leader lease valkey; new leader is valkey-1")
+	}) {
+		t.Errorf("expected LeaseLost event; got %q", events)
+	}
+	if !slices.ContainsFunc(events, func(event string) bool {
+		return strings.HasPrefix(event, "Warning Demoted ")
+	}) {
+		t.Errorf("expected Demoted event; got %q", events)
+	}
+}
@@ -0,0 +1,414 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/sapslaj/valkey-leader/pkg/ptr"
+)
+
+// fakeValkey is an in-memory stand-in for a Valkey instance that tracks its
+// replication role.
+type fakeValkey struct {
+	mu          sync.Mutex
+	role        string
+	primaryHost string
+	primaryPort int64
+	offset      int64
+	replicas    []ReplicaInfo
+	paused      bool
+	linkStatus  string
+	announced   string
+	authErr     error
+	loading     bool
+	syncing     bool
+	dbSize      int64
+	err         error
+	calls       []string
+	// block, if set, makes PromoteToPrimary hang until it is closed,
+	// regardless of its context.
+	block chan struct{}
+}
+
+func newFakeValkey() *fakeValkey {
+	return &fakeValkey{
+		role: RolePrimary,
+	}
+}
+
+func (fv *fakeValkey) Ping(ctx context.Context) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.err
+}
+
+func (fv *fakeValkey) ReplicaOf(ctx context.Context, host string, port int64) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.calls = append(fv.calls, "REPLICAOF")
+	if fv.err != nil {
+		return fv.err
+	}
+	fv.role = RoleReplica
+	fv.primaryHost = host
+	fv.primaryPort = port
+	return nil
+}
+
+func (fv *fakeValkey) PromoteToPrimary(ctx context.Context) error {
+	fv.mu.Lock()
+	block := fv.block
+	fv.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.calls = append(fv.calls, "REPLICAOF NO ONE")
+	if fv.err != nil {
+		return fv.err
+	}
+	fv.role = RolePrimary
+	fv.primaryHost = ""
+	fv.primaryPort = 0
+	return nil
+}
+
+func (fv *fakeValkey) ReplicationInfo(ctx context.Context) (ReplicationInfo, error) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	if fv.err != nil {
+		return ReplicationInfo{}, fv.err
+	}
+	replication := ReplicationInfo{
+		Role:                "master",
+		MasterReplOffset:    fv.offset,
+		MasterFailoverState: "no-failover",
+	}
+	if fv.role == RoleReplica {
+		replication.Role = "slave"
+		replication.MasterLinkStatus = "up"
+		replication.MasterSyncInProgress = fv.syncing
+		if fv.linkStatus != "" {
+			replication.MasterLinkStatus = fv.linkStatus
+		}
+	} else {
+		replication.Replicas = fv.replicas
+	}
+	return replication, nil
+}
+
+func (fv *fakeValkey) DBSize(ctx context.Context) (int64, error) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.dbSize, fv.err
+}
+
+func (fv *fakeValkey) Loading(ctx context.Context) (bool, error) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.loading, fv.err
+}
+
+func (fv *fakeValkey) Failover(ctx context.Context, host string, port int64, timeout time.Duration) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.calls = append(fv.calls, "FAILOVER")
+	if fv.err != nil {
+		return fv.err
+	}
+	fv.role = RoleReplica
+	fv.primaryHost = host
+	fv.primaryPort = port
+	return nil
+}
+
+func (fv *fakeValkey) PauseWrites(ctx context.Context, timeout time.Duration) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.calls = append(fv.calls, "CLIENT PAUSE WRITE")
+	if fv.err != nil {
+		return fv.err
+	}
+	fv.paused = true
+	return nil
+}
+
+func (fv *fakeValkey) UnpauseWrites(ctx context.Context) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.calls = append(fv.calls, "CLIENT UNPAUSE")
+	if fv.err != nil {
+		return fv.err
+	}
+	fv.paused = false
+	return nil
+}
+
+func (fv *fakeValkey) AnnounceReplicaAddress(ctx context.Context, host string, port int64) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.calls = append(fv.calls, "CONFIG SET replica-announce-ip")
+	if fv.err != nil {
+		return fv.err
+	}
+	fv.announced = host + ":" + strconv.FormatInt(port, 10)
+	return nil
+}
+
+func (fv *fakeValkey) CheckPrimaryAuth(ctx context.Context, host string, port int64) error {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.authErr
+}
+
+func (fv *fakeValkey) Paused() bool {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.paused
+}
+
+func (fv *fakeValkey) Role() string {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.role
+}
+
+func testPod(name string, ip string, labels map[string]string) *corev1.Pod {
+	podLabels := map[string]string{
+		LabelCluster: "valkey",
+	}
+	maps.Copy(podLabels, labels)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    podLabels,
+		},
+		Status: corev1.PodStatus{
+			PodIP: ip,
+			Conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodReady,
+					Status: corev1.ConditionTrue,
+				},
+			},
+		},
+	}
+}
+
+func unreadyPod(pod *corev1.Pod) *corev1.Pod {
+	pod.Status.Conditions = nil
+	return pod
+}
+
+func testConfig(podName string, podIP string) Config {
+	return Config{
+		ClusterName:       "valkey",
+		Namespace:         "default",
+		PodIP:             podIP,
+		PodName:           podName,
+		ServiceName:       "valkey-headless",
+		LeaderLeaseName:   "valkey",
+		ReconcileInterval: 10 * time.Millisecond,
+		LeaseDuration:     2 * time.Second,
+		RenewDeadline:     1 * time.Second,
+		RetryPeriod:       100 * time.Millisecond,
+		SwitchoverTimeout: time.Second,
+		FenceTimeout:      time.Second,
+		Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func testLease(holder string) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "valkey",
+			Namespace: "default",
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.Of(holder),
+			LeaseDurationSeconds: ptr.Of(int32(5)),
+			LeaseTransitions:     ptr.Of(int32(3)),
+		},
+	}
+}
+
+// testClientset returns a fake clientset seeded with pods. The first pod of
+// the cluster labeled primary also holds the leader lease, as it would in a
+// running cluster.
+func testClientset(pods ...*corev1.Pod) *fake.Clientset {
+	objects := make([]runtime.Object, 0, len(pods)+1)
+	var lease *coordinationv1.Lease
+	for _, pod := range pods {
+		objects = append(objects, pod)
+		if lease == nil && pod.Labels[LabelCluster] == "valkey" && pod.Labels[LabelInstanceRole] == RolePrimary {
+			lease = testLease(electionIdentity(pod.Name, string(pod.UID)))
+			objects = append(objects, lease)
+		}
+	}
+	return fake.NewClientset(objects...)
+}
+
+// enforceLeaseVersions makes client bump the resourceVersion of the lease on
+// every write and reject updates carrying a stale one, as the API server does.
+// It returns the number of updates rejected so far.
+func enforceLeaseVersions(client *fake.Clientset) *atomic.Int32 {
+	var mu sync.Mutex
+	var version int
+	conflicts := &atomic.Int32{}
+	gvr := coordinationv1.SchemeGroupVersion.WithResource("leases")
+	client.PrependReactor("*", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Create and update actions share a method set, so they are told
+		// apart by verb.
+		switch action.GetVerb() {
+		case "create":
+			lease := action.(k8stesting.CreateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+			version++
+			lease.ResourceVersion = strconv.Itoa(version)
+			return true, lease, client.Tracker().Create(gvr, lease, action.GetNamespace())
+		case "update":
+			lease := action.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+			current, err := client.Tracker().Get(gvr, action.GetNamespace(), lease.Name)
+			if err != nil {
+				return true, nil, err
+			}
+			if current.(*coordinationv1.Lease).ResourceVersion != lease.ResourceVersion {
+				conflicts.Add(1)
+				return true, nil, apierrors.NewConflict(gvr.GroupResource(), lease.Name, errors.New("the object has been modified"))
+			}
+			version++
+			lease.ResourceVersion = strconv.Itoa(version)
+			return true, lease, client.Tracker().Update(gvr, lease, action.GetNamespace())
+		case "patch":
+			_, obj, err := k8stesting.ObjectReaction(client.Tracker())(action)
+			if err != nil {
+				return true, nil, err
+			}
+			lease := obj.(*coordinationv1.Lease)
+			version++
+			lease.ResourceVersion = strconv.Itoa(version)
+			return true, lease, client.Tracker().Update(gvr, lease, action.GetNamespace())
+		}
+		return false, nil, nil
+	})
+	return conflicts
+}
+
+// testController returns a Controller for podName backed by a fake clientset
+// seeded with pods, with its informers started.
+func testController(t *testing.T, podName string, podIP string, pods ...*corev1.Pod) (*Controller, *fake.Clientset, *fakeValkey) {
+	t.Helper()
+
+	client := testClientset(pods...)
+	fv := newFakeValkey()
+	c := NewController(testConfig(podName, podIP), client, fv)
+	err := c.StartInformers(t.Context())
+	if err != nil {
+		t.Fatalf("failed to start informers: %v", err)
+	}
+	return c, client, fv
+}
+
+func podLabel(t *testing.T, client *fake.Clientset, name string, key string) string {
+	t.Helper()
+
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod %s: %v", name, err)
+	}
+	return pod.Labels[key]
+}
+
+func leaseHolder(t *testing.T, client *fake.Clientset) string {
+	t.Helper()
+
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	return ptr.From(lease.Spec.HolderIdentity)
+}
+
+// setLeaseHolder hands the leader lease to holder, creating it if needed.
+func setLeaseHolder(t *testing.T, client *fake.Clientset, holder string) {
+	t.Helper()
+
+	leases := client.CoordinationV1().Leases("default")
+	lease, err := leases.Get(context.Background(), "valkey", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(context.Background(), testLease(holder), metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("failed to create lease: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	lease.Spec.HolderIdentity = ptr.Of(holder)
+	_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("failed to update lease: %v", err)
+	}
+}
+
+func setPodLabel(t *testing.T, client *fake.Clientset, name string, key string, value string) {
+	t.Helper()
+
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod %s: %v", name, err)
+	}
+	pod.Labels[key] = value
+	_, err = client.CoreV1().Pods("default").Update(context.Background(), pod, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("failed to update pod %s: %v", name, err)
+	}
+}
+
+// lead moves c to StatePrimary as if it had won the election and promoted
+// the local Valkey.
+func lead(t *testing.T, c *Controller) {
+	t.Helper()
+
+	c.state.fire(TriggerReady)
+	c.OnStartedLeading(t.Context())
+	_, ok := c.state.fire(TriggerPromoted)
+	if !ok {
+		t.Fatalf("failed to move to %s from %s", StatePrimary, c.State())
+	}
+}
+
+func eventually(t *testing.T, timeout time.Duration, f func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if f() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("condition not met within %s", timeout)
+}
@@ -0,0 +1,117 @@
+package leader
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Fence pauses writes on the local Valkey so that a primary which lost the
+// lease can't keep accepting writes alongside the new primary. The pause
+// expires after FenceTimeout unless it is refreshed, so a fence left behind by
+// a dead sidecar doesn't block writes forever.
+func (c *Controller) Fence(ctx context.Context, reason string) error {
+	start := time.Now()
+	err := c.valkey.PauseWrites(ctx, c.config.FenceTimeout)
+	if err != nil {
+		return err
+	}
+
+	if c.fencedSince.CompareAndSwap(0, start.UnixNano()) {
+		c.metrics.observeFence()
+		c.logger.Warn(
+			"fenced local Valkey",
+			slog.String("reason", reason),
+			slog.Duration("fence_timeout", c.config.FenceTimeout),
+			slog.Duration("duration", time.Since(start)),
+		)
+	}
+	return nil
+}
+
+// Fenced reports whether the local Valkey is currently fenced.
+func (c *Controller) Fenced() bool {
+	return c.fencedSince.Load() != 0
+}
+
+// refreshFence extends the write pause while this pod is fenced and has not
+// yet found a new primary to replicate from.
+func (c *Controller) refreshFence(ctx context.Context) {
+	if !c.Fenced() {
+		return
+	}
+	err := c.valkey.PauseWrites(ctx, c.config.FenceTimeout)
+	if err != nil {
+		c.logError("failed to refresh fence", err)
+	}
+}
+
+// Unfence lifts the write pause on the local Valkey.
+func (c *Controller) Unfence(ctx context.Context) error {
+	since := c.fencedSince.Load()
+	if since == 0 {
+		return nil
+	}
+
+	err := c.valkey.UnpauseWrites(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.fencedSince.CompareAndSwap(since, 0) {
+		fencedFor := time.Since(time.Unix(0, since))
+		c.metrics.observeUnfence(fencedFor)
+		c.logger.Info("unfenced local Valkey", slog.Duration("fenced_for", fencedFor))
+	}
+	return nil
+}
+
+// fenceOnStartup removes any role label a previous run of the sidecar left on
+// the pod, and fences the local Valkey unless it is already a read-only
+// replica. Valkey restarted in place comes back as a standalone primary, so
+// until a reconcile confirms the pod's role it must neither be routed writes
+// nor accept them. If Valkey can't be reached yet, the pod is still marked as
+// fenced so that the reconcile loop applies the pause as soon as Valkey answers.
+func (c *Controller) fenceOnStartup(ctx context.Context) error {
+	err := c.RemoveRoleLabel(ctx)
+	if err != nil {
+		return err
+	}
+
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err == nil && replication.Role == "slave" {
+		return nil
+	}
+	err = c.Fence(ctx, "startup")
+	if err != nil {
+		c.logger.Warn("failed to fence local Valkey on startup, fencing once it is reachable", slog.Any("error", err))
+		if c.fencedSince.CompareAndSwap(0, time.Now().UnixNano()) {
+			c.metrics.observeFence()
+		}
+	}
+	return nil
+}
+
+// fenceAfterLeadershipLoss fences the local Valkey unless it already
+// replicates from another primary, e.g. after a switchover, then drops the
+// primary label so Services stop routing writes to this pod.
+func (c *Controller) fenceAfterLeadershipLoss(ctx context.Context) {
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err == nil && replication.Role == "slave" {
+		return
+	}
+
+	err = c.Fence(ctx, "leader lease lost")
+	if err != nil {
+		c.logger.Error("failed to fence local Valkey", slog.Any("error", err))
+	} else {
+		c.event(corev1.EventTypeWarning, EventDemoted, "Demoted after losing the leader lease; writes fenced for up to %s", c.config.FenceTimeout)
+	}
+
+	err = c.RemoveRoleLabel(ctx)
+	if err != nil {
+		c.logger.Error("failed to remove primary label", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,157 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOnStoppedLeadingFences(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	lead(t, c)
+
+	c.OnStoppedLeading()
+
+	if !fv.Paused() || !c.Fenced() {
+		t.Fatalf("expected local Valkey to be fenced")
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != "" {
+		t.Errorf("role label: expected none; got %q", got)
+	}
+
+	// Stays fenced while there is no new primary.
+	err := c.ReconcileReplica(context.Background())
+	if err == nil {
+		t.Fatalf("expected error without a primary")
+	}
+	if !fv.Paused() {
+		t.Errorf("expected local Valkey to stay fenced")
+	}
+
+	// Unfenced once it replicates from the new primary.
+	setLeaseHolder(t, client, "valkey-1")
+	eventually(t, time.Second, func() bool {
+		return c.ReconcileReplica(context.Background()) == nil
+	})
+	if fv.Paused() || c.Fenced() {
+		t.Errorf("expected local Valkey to be unfenced")
+	}
+	if fv.Role() != RoleReplica || fv.primaryHost != "10.0.0.2" {
+		t.Errorf("expected replica of 10.0.0.2; got %s of %s", fv.Role(), fv.primaryHost)
+	}
+}
+
+func TestOnStoppedLeadingAfterSwitchover(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	fv.role = RoleReplica
+	lead(t, c)
+
+	c.OnStoppedLeading()
+
+	if fv.Paused() || c.Fenced() {
+		t.Errorf("expected replica not to be fenced")
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != RoleReplica {
+		t.Errorf("role label: expected %s; got %q", RoleReplica, got)
+	}
+}
+
+func TestOnStoppedLeadingWithoutLeading(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	c.OnStoppedLeading()
+
+	if len(fv.calls) != 0 {
+		t.Errorf("expected no Valkey commands; got %v", fv.calls)
+	}
+}
+
+func TestReconcilePrimaryUnfences(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	err := c.Fence(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.ReconcilePrimary(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.Paused() || c.Fenced() {
+		t.Errorf("expected local Valkey to be unfenced")
+	}
+}
+
+func TestFenceOnStartup(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+	)
+
+	err := c.fenceOnStartup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != "" {
+		t.Errorf("role label: expected none; got %q", got)
+	}
+	if !fv.Paused() || !c.Fenced() {
+		t.Errorf("expected local Valkey to be fenced")
+	}
+
+	err = c.ReconcilePrimary(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.Paused() || c.Fenced() {
+		t.Errorf("expected local Valkey to be unfenced once its role is confirmed")
+	}
+}
+
+func TestFenceOnStartupReplica(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	fv.role = RoleReplica
+
+	err := c.fenceOnStartup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != "" {
+		t.Errorf("role label: expected none; got %q", got)
+	}
+	if fv.Paused() || c.Fenced() {
+		t.Errorf("expected a replica not to be fenced")
+	}
+}
+
+func TestFenceOnStartupUnreachable(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.err = errors.New("connection refused")
+
+	err := c.fenceOnStartup(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.Fenced() {
+		t.Fatalf("expected pod to be marked as fenced")
+	}
+
+	// The pause is applied once Valkey answers and there is no primary yet.
+	fv.err = nil
+	err = c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrNoPrimary) {
+		t.Fatalf("expected ErrNoPrimary; got %v", err)
+	}
+	c.refreshFence(context.Background())
+	if !fv.Paused() {
+		t.Errorf("expected local Valkey to be fenced once reachable")
+	}
+}
@@ -0,0 +1,116 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	ErrValkeyUnhealthy = errors.New("local valkey is unhealthy")
+	ErrValkeyNotReady  = errors.New("local valkey is not ready")
+)
+
+// CheckValkeyHealth returns an error wrapping ErrValkeyUnhealthy unless the
+// local Valkey answers PING and has finished loading its dataset.
+func (c *Controller) CheckValkeyHealth(ctx context.Context) error {
+	err := c.valkey.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValkeyUnhealthy, err)
+	}
+	loading, err := c.valkey.Loading(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValkeyUnhealthy, err)
+	}
+	if loading {
+		return fmt.Errorf("%w: loading dataset", ErrValkeyUnhealthy)
+	}
+	return nil
+}
+
+// CheckValkeyReady returns an error unless the local Valkey is healthy and
+// not in the middle of a full sync from a primary, i.e. unless its dataset is
+// complete enough for it to become the primary.
+func (c *Controller) CheckValkeyReady(ctx context.Context) error {
+	err := c.CheckValkeyHealth(ctx)
+	if err != nil {
+		return err
+	}
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValkeyNotReady, err)
+	}
+	if replication.MasterSyncInProgress {
+		return fmt.Errorf("%w: sync from primary in progress", ErrValkeyNotReady)
+	}
+	return nil
+}
+
+// waitForValkeyReady polls CheckValkeyReady every RetryPeriod until it
+// succeeds or ctx is canceled.
+func (c *Controller) waitForValkeyReady(ctx context.Context) error {
+	waiting := false
+	for {
+		err := c.CheckValkeyReady(ctx)
+		if err == nil {
+			if waiting {
+				c.logger.Info("local Valkey is ready, joining the leader election")
+			}
+			return nil
+		}
+		if !waiting {
+			c.logger.Info("waiting for local Valkey to be ready before joining the leader election", slog.Any("reason", err))
+			waiting = true
+		} else {
+			c.logger.Debug("local Valkey is not ready yet", slog.Any("reason", err))
+		}
+
+		select {
+		case <-time.After(c.config.RetryPeriod):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkPrimaryHealth health-checks the local Valkey while this pod is the
+// primary, and steps down once UnhealthyThreshold checks in a row have
+// failed. client-go renews the lease regardless of Valkey's state, so without
+// this a primary whose Valkey is crash-looping or stuck loading would keep
+// the lease forever.
+func (c *Controller) checkPrimaryHealth(ctx context.Context) error {
+	err := c.CheckValkeyHealth(ctx)
+	if err == nil {
+		if c.unhealthyChecks > 0 {
+			c.logger.Info("local Valkey is healthy again", slog.Int("failed_checks", c.unhealthyChecks))
+		}
+		c.unhealthyChecks = 0
+		return nil
+	}
+
+	c.unhealthyChecks++
+	c.logger.Warn("local Valkey health check failed", slog.Any("error", err), slog.Int("failed_checks", c.unhealthyChecks))
+	threshold := c.config.UnhealthyThreshold
+	if threshold > 0 && c.unhealthyChecks >= threshold {
+		c.event(corev1.EventTypeWarning, EventSteppedDown, "Stepping down as primary after %d failed health checks: %v", c.unhealthyChecks, err)
+		c.StepDown()
+	}
+	return err
+}
+
+// StepDown releases the leader lease if this pod holds it. The pod rejoins
+// the election right away, but the promotion gate keeps it from winning
+// until its Valkey is healthy.
+func (c *Controller) StepDown() {
+	c.electionMu.Lock()
+	defer c.electionMu.Unlock()
+
+	if c.cancelElection != nil {
+		c.logger.Info("stepping down as leader")
+		c.cancelElection()
+	}
+}
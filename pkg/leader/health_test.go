@@ -0,0 +1,126 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckValkeyHealth(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	err := c.CheckValkeyHealth(context.Background())
+	if err != nil {
+		t.Errorf("healthy: unexpected error: %v", err)
+	}
+
+	fv.loading = true
+	err = c.CheckValkeyHealth(context.Background())
+	if !errors.Is(err, ErrValkeyUnhealthy) {
+		t.Errorf("loading: expected ErrValkeyUnhealthy; got %v", err)
+	}
+
+	fv.loading = false
+	fv.err = errors.New("connection refused")
+	err = c.CheckValkeyHealth(context.Background())
+	if !errors.Is(err, ErrValkeyUnhealthy) {
+		t.Errorf("unreachable: expected ErrValkeyUnhealthy; got %v", err)
+	}
+}
+
+func TestCheckPromotionUnhealthy(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.loading = true
+
+	err := c.CheckPromotion(context.Background())
+	if !errors.Is(err, ErrValkeyUnhealthy) {
+		t.Errorf("expected ErrValkeyUnhealthy; got %v", err)
+	}
+}
+
+func TestPrimaryStepsDownWhenUnhealthy(t *testing.T) {
+	client := testClientset(testPod("valkey-0", "10.0.0.1", nil))
+	fv := newFakeValkey()
+	config := testConfig("valkey-0", "10.0.0.1")
+	config.UnhealthyThreshold = 2
+	c := NewController(config, client, fv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	eventually(t, 5*time.Second, c.Leading)
+
+	fv.mu.Lock()
+	fv.loading = true
+	fv.mu.Unlock()
+	eventually(t, 5*time.Second, func() bool {
+		return !c.Leading() && leaseHolder(t, client) == ""
+	})
+
+	fv.mu.Lock()
+	fv.loading = false
+	fv.mu.Unlock()
+	eventually(t, 5*time.Second, c.Leading)
+}
+
+func TestCheckValkeyReadySyncing(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.role = RoleReplica
+	fv.syncing = true
+
+	err := c.CheckValkeyReady(context.Background())
+	if !errors.Is(err, ErrValkeyNotReady) {
+		t.Errorf("syncing: expected ErrValkeyNotReady; got %v", err)
+	}
+	err = c.CheckPromotion(context.Background())
+	if !errors.Is(err, ErrValkeyNotReady) {
+		t.Errorf("promotion while syncing: expected ErrValkeyNotReady; got %v", err)
+	}
+
+	fv.syncing = false
+	err = c.CheckValkeyReady(context.Background())
+	if err != nil {
+		t.Errorf("synced: unexpected error: %v", err)
+	}
+}
+
+func TestRunWaitsForValkeyReady(t *testing.T) {
+	client := testClientset(testPod("valkey-0", "10.0.0.1", nil))
+	fv := newFakeValkey()
+	fv.loading = true
+	c := NewController(testConfig("valkey-0", "10.0.0.1"), client, fv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	if c.Leading() {
+		t.Fatalf("expected pod to stay out of the election while Valkey is loading")
+	}
+	_, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("expected no lease to be created while Valkey is loading")
+	}
+
+	fv.mu.Lock()
+	fv.loading = false
+	fv.mu.Unlock()
+	eventually(t, 5*time.Second, c.Leading)
+}
@@ -0,0 +1,70 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readyzTimeout bounds how long /readyz waits for the local Valkey to answer.
+const readyzTimeout = time.Second
+
+// Handler returns an http.Handler serving the sidecar's probe and status
+// endpoints:
+//
+//   - /healthz reports that the process is alive.
+//   - /readyz reports whether the local Valkey is reachable and the role has
+//     been reconciled.
+//   - /role returns the Controller's Status as JSON.
+//   - /metrics exposes the Controller's Prometheus metrics.
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", c.handleHealthz)
+	mux.HandleFunc("GET /readyz", c.handleReadyz)
+	mux.HandleFunc("GET /role", c.handleRole)
+	mux.Handle("GET /metrics", c.metrics.Handler())
+	return mux
+}
+
+func (c *Controller) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeText(w, http.StatusOK, "ok")
+}
+
+func (c *Controller) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+	defer cancel()
+
+	err := c.valkey.Ping(ctx)
+	if err != nil {
+		writeText(w, http.StatusServiceUnavailable, "valkey unreachable: "+err.Error())
+		return
+	}
+
+	status := c.Status()
+	if status.LastReconcileTime == nil {
+		writeText(w, http.StatusServiceUnavailable, "role not reconciled yet")
+		return
+	}
+	if status.LastReconcileError != "" {
+		writeText(w, http.StatusServiceUnavailable, "reconcile failed: "+status.LastReconcileError)
+		return
+	}
+
+	writeText(w, http.StatusOK, "ok")
+}
+
+func (c *Controller) handleRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(c.Status())
+	if err != nil {
+		c.logger.Error("failed to write role response", slog.Any("error", err))
+	}
+}
+
+func writeText(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(body + "\n"))
+}
@@ -0,0 +1,84 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder
+}
+
+func TestHealthz(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.err = errors.New("connection refused")
+
+	if got := get(t, c.Handler(), "/healthz").Code; got != http.StatusOK {
+		t.Errorf("expected %d; got %d", http.StatusOK, got)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	handler := c.Handler()
+
+	if got := get(t, handler, "/readyz").Code; got != http.StatusServiceUnavailable {
+		t.Errorf("before reconcile: expected %d; got %d", http.StatusServiceUnavailable, got)
+	}
+
+	c.recordReconcile(RolePrimary, 0, c.ReconcilePrimary(context.Background()))
+	if got := get(t, handler, "/readyz").Code; got != http.StatusOK {
+		t.Errorf("after reconcile: expected %d; got %d", http.StatusOK, got)
+	}
+
+	c.recordReconcile(RoleReplica, 0, ErrNoPrimary)
+	if got := get(t, handler, "/readyz").Code; got != http.StatusServiceUnavailable {
+		t.Errorf("after failed reconcile: expected %d; got %d", http.StatusServiceUnavailable, got)
+	}
+
+	c.recordReconcile(RolePrimary, 0, nil)
+	fv.err = errors.New("connection refused")
+	if got := get(t, handler, "/readyz").Code; got != http.StatusServiceUnavailable {
+		t.Errorf("with Valkey down: expected %d; got %d", http.StatusServiceUnavailable, got)
+	}
+}
+
+func TestRole(t *testing.T) {
+	c, _, _ := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	c.OnNewLeader("valkey-0")
+	c.recordReconcile(RoleReplica, 0, c.ReconcileReplica(context.Background()))
+
+	recorder := get(t, c.Handler(), "/role")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected %d; got %d", http.StatusOK, recorder.Code)
+	}
+	var status Status
+	err := json.Unmarshal(recorder.Body.Bytes(), &status)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if status.Role != RoleReplica {
+		t.Errorf("role: expected %s; got %q", RoleReplica, status.Role)
+	}
+	if status.LeaseHolder != "valkey-0" {
+		t.Errorf("lease holder: expected valkey-0; got %q", status.LeaseHolder)
+	}
+	if status.ObservedPrimary != "valkey-0" || status.ObservedPrimaryAddress != "10.0.0.1" {
+		t.Errorf("observed primary: expected valkey-0 (10.0.0.1); got %s (%s)", status.ObservedPrimary, status.ObservedPrimaryAddress)
+	}
+	if status.LastReconcileTime == nil || status.LastReconcileError != "" {
+		t.Errorf("expected successful reconcile; got %v %q", status.LastReconcileTime, status.LastReconcileError)
+	}
+}
@@ -0,0 +1,157 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sapslaj/valkey-leader/pkg/ptr"
+)
+
+func (c *Controller) setupInformers() {
+	c.informerFactory = informers.NewSharedInformerFactoryWithOptions(
+		c.client,
+		0,
+		informers.WithNamespace(c.config.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = c.clusterSelector().String()
+		}),
+	)
+	c.leaseInformerFactory = informers.NewSharedInformerFactoryWithOptions(
+		c.client,
+		0,
+		informers.WithNamespace(c.config.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", c.config.LeaderLeaseName).String()
+		}),
+	)
+
+	c.podLister = c.informerFactory.Core().V1().Pods().Lister()
+}
+
+// StartInformers starts the pod and leader lease informers and waits for
+// their caches to sync.
+func (c *Controller) StartInformers(ctx context.Context) error {
+	_, err := c.informerFactory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if pod, ok := obj.(*corev1.Pod); ok && c.isPrimaryPod(pod) {
+				c.triggerReconcile("primary pod added", pod.Name)
+			}
+		},
+		UpdateFunc: func(oldObj any, newObj any) {
+			oldPod, ok := oldObj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			newPod, ok := newObj.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			if !c.isPrimaryPod(oldPod) && !c.isPrimaryPod(newPod) {
+				return
+			}
+			if c.isPrimaryPod(oldPod) != c.isPrimaryPod(newPod) ||
+				oldPod.Status.PodIP != newPod.Status.PodIP ||
+				PodReady(oldPod) != PodReady(newPod) {
+				c.triggerReconcile("primary pod changed", newPod.Name)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok && c.isPrimaryPod(pod) {
+				c.triggerReconcile("primary pod deleted", pod.Name)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.leaseInformerFactory.Coordination().V1().Leases().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if lease, ok := obj.(*coordinationv1.Lease); ok {
+				c.leaseChanged("", lease)
+			}
+		},
+		UpdateFunc: func(oldObj any, newObj any) {
+			oldLease, ok := oldObj.(*coordinationv1.Lease)
+			if !ok {
+				return
+			}
+			newLease, ok := newObj.(*coordinationv1.Lease)
+			if !ok {
+				return
+			}
+			c.leaseChanged(ptr.From(oldLease.Spec.HolderIdentity), newLease)
+		},
+		DeleteFunc: func(obj any) {
+			c.recordLeaseHolder("")
+			c.triggerReconcile("leader lease deleted", c.config.LeaderLeaseName)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	c.informerFactory.Start(ctx.Done())
+	c.leaseInformerFactory.Start(ctx.Done())
+	for _, factory := range []informers.SharedInformerFactory{c.informerFactory, c.leaseInformerFactory} {
+		for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return errors.New("failed to sync informer cache for " + informerType.String())
+			}
+		}
+	}
+	return nil
+}
+
+// leaseChanged records the holder of the leader lease and has the reconcile
+// loop follow it when it changes.
+func (c *Controller) leaseChanged(oldHolder string, lease *coordinationv1.Lease) {
+	holder := ptr.From(lease.Spec.HolderIdentity)
+	if holder == oldHolder {
+		return
+	}
+	c.recordLeaseHolder(holder)
+	c.triggerReconcile("leader lease holder changed", lease.Name)
+}
+
+// LeaseHolder returns the identity holding the leader lease, or "" if the
+// lease does not exist or is not held. Both the lease informer and the leader
+// election report holder changes, and whichever saw the latest change wins:
+// the election can observe a new holder before the informer cache does.
+func (c *Controller) LeaseHolder() string {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.status.LeaseHolder
+}
+
+func (c *Controller) clusterSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{LabelCluster: c.config.ClusterName})
+}
+
+// isPrimaryPod reports whether pod is the one holding the leader lease.
+func (c *Controller) isPrimaryPod(pod *corev1.Pod) bool {
+	holder := c.LeaseHolder()
+	return holder != "" && hasIdentity(pod, holder)
+}
+
+// triggerReconcile asks the reconcile loop to reconcile without waiting for the
+// next interval. Triggers coalesce while a reconcile is already pending.
+func (c *Controller) triggerReconcile(reason string, object string) {
+	select {
+	case c.reconcileTrigger <- struct{}{}:
+		c.logger.Debug("triggered reconcile", slog.String("reason", reason), slog.String("object", object))
+	default:
+	}
+}
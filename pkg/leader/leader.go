@@ -0,0 +1,615 @@
+// Package leader implements the valkey-leader sidecar: it runs a Kubernetes
+// leader election for a Valkey cluster, configures the local Valkey as primary
+// or replica accordingly, and labels the pod with its role.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	LabelCluster      = "valkey.sapslaj.cloud/cluster"
+	LabelInstanceRole = "valkey.sapslaj.cloud/instance-role"
+
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+
+	AnnotationReplicationOffset     = "valkey.sapslaj.cloud/replication-offset"
+	AnnotationReplicationOffsetTime = "valkey.sapslaj.cloud/replication-offset-time"
+	AnnotationValkeyPort            = "valkey.sapslaj.cloud/port"
+	AnnotationDBSize                = "valkey.sapslaj.cloud/dbsize"
+	AnnotationIdentity              = "valkey.sapslaj.cloud/identity"
+	AnnotationPodIP                 = "valkey.sapslaj.cloud/pod-ip"
+
+	// ValkeyPortName is the name of the container port Valkey listens on.
+	ValkeyPortName    = "redis"
+	DefaultValkeyPort = 6379
+
+	// DefaultAPITimeout bounds a single Kubernetes API request when
+	// Config.APITimeout is zero.
+	DefaultAPITimeout = 10 * time.Second
+)
+
+var (
+	ErrNoPrimary = errors.New("no primary pod found")
+	ErrDraining  = errors.New("shutting down")
+)
+
+type Config struct {
+	ClusterName string
+	Namespace   string
+	PodIP       string
+	PodName     string
+	// PodUID is the UID of the current pod. Together with PodName it forms
+	// the identity used in the leader election.
+	PodUID      string
+	ServiceName string
+	// ValkeyPort is the port the local Valkey listens on. If zero,
+	// DefaultValkeyPort is used.
+	ValkeyPort        int64
+	LeaderLeaseName   string
+	ReconcileInterval time.Duration
+	LeaseDuration     time.Duration
+	RenewDeadline     time.Duration
+	RetryPeriod       time.Duration
+	// PromotionLagTolerance is how many bytes of replication offset a healthy
+	// peer may be ahead of this pod before this pod defers the election to it.
+	PromotionLagTolerance int64
+	// SwitchoverTimeout bounds how long the primary waits for a FAILOVER to a
+	// replica to complete during shutdown.
+	SwitchoverTimeout time.Duration
+	// FenceTimeout is how long a write pause placed on the local Valkey after
+	// losing the lease lasts unless it is refreshed.
+	FenceTimeout time.Duration
+	// ReplicationAddressMode is how replicas address their primary, either
+	// ReplicationAddressIP (the default) or ReplicationAddressDNS.
+	ReplicationAddressMode string
+	// ReplicationLinkTimeout is how long a replica's link to its primary may
+	// stay down after REPLICAOF before the reconcile reports it as failed.
+	ReplicationLinkTimeout time.Duration
+	// APITimeout bounds each Kubernetes API request the Controller makes. If
+	// zero, DefaultAPITimeout is used.
+	APITimeout time.Duration
+	// WatchdogTimeout is how long a single iteration of the reconcile loop
+	// may take before Run releases the lease and returns ErrWatchdogTimeout.
+	// If zero, the watchdog is disabled.
+	WatchdogTimeout time.Duration
+	// UnhealthyThreshold is how many consecutive failed health checks of the
+	// local Valkey make the primary step down. If zero, it never steps down.
+	UnhealthyThreshold int
+	// Metrics receives the Controller's metrics. If nil, metrics are recorded
+	// in a private registry.
+	Metrics *Metrics
+	// Recorder records Events against the pod. If nil, Events are dropped.
+	Recorder record.EventRecorder
+	Logger   *slog.Logger
+}
+
+type Controller struct {
+	config   Config
+	client   kubernetes.Interface
+	valkey   Valkey
+	logger   *slog.Logger
+	metrics  *Metrics
+	recorder record.EventRecorder
+	state    *stateMachine
+	// fencedSince is the time in Unix nanoseconds the local Valkey was fenced
+	// at, or 0 if it is not fenced.
+	fencedSince atomic.Int64
+	// localOffset is the replication offset of the local Valkey the loop last
+	// read, at localOffsetTime in Unix nanoseconds, or 0 if it has not read one.
+	localOffset     atomic.Int64
+	localOffsetTime atomic.Int64
+
+	labelsMu sync.Mutex
+	// appliedLabels holds the pod labels this process last applied; a nil
+	// value means the label was removed.
+	appliedLabels map[string]*string
+
+	statusMu sync.Mutex
+	status   Status
+	// replicationSource is the primary address the last successful replica
+	// reconcile pointed the local Valkey at.
+	replicationSource string
+
+	// linkPrimary and linkDownSince track since when the replication link to
+	// linkPrimary has been down. They are only used by the loop.
+	linkPrimary   string
+	linkDownSince time.Time
+
+	// offsetPublishedAt and publishedDBSize record when the loop last
+	// published the replication offset on the pod, and with what dataset
+	// size. They are only used by the loop.
+	offsetPublishedAt time.Time
+	publishedDBSize   int64
+
+	loopWatch loopWatch
+
+	// reconciledTerm is the leader term the loop last reconciled as the
+	// primary in. It is only used by the loop.
+	reconciledTerm int
+	// unhealthyChecks counts consecutive failed health checks of the local
+	// Valkey. It is only used by the loop.
+	unhealthyChecks int
+	// datasetChecked is set once the dataset of the local Valkey has been
+	// found safe to promote in the current term. It is only used by the loop.
+	datasetChecked bool
+
+	electionMu sync.Mutex
+	// cancelElection ends the current leader election, releasing the lease
+	// if it is held unless keepLease is set. electionDone is closed once it
+	// has ended.
+	cancelElection context.CancelFunc
+	electionDone   chan struct{}
+	keepLease      atomic.Bool
+	// leaderCtx is canceled when this pod loses the lease it acquired in
+	// leaderTerm, the number of times it has acquired the lease.
+	leaderCtx  context.Context
+	leaderTerm int
+
+	informerFactory      informers.SharedInformerFactory
+	leaseInformerFactory informers.SharedInformerFactory
+	podLister            corelisters.PodLister
+	reconcileTrigger     chan struct{}
+}
+
+func NewController(config Config, client kubernetes.Interface, valkey Valkey) *Controller {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = NewMetrics(prometheus.NewRegistry())
+	}
+	recorder := config.Recorder
+	if recorder == nil {
+		recorder = &record.FakeRecorder{}
+	}
+	c := &Controller{
+		config:           config,
+		client:           client,
+		valkey:           valkey,
+		logger:           logger,
+		metrics:          metrics,
+		recorder:         recorder,
+		state:            newStateMachine(logger),
+		reconcileTrigger: make(chan struct{}, 1),
+		appliedLabels:    map[string]*string{},
+	}
+	c.setupInformers()
+	return c
+}
+
+// Identity returns the identity this pod uses in the leader election.
+func (c *Controller) Identity() string {
+	return electionIdentity(c.config.PodName, c.config.PodUID)
+}
+
+func (c *Controller) valkeyPort() int64 {
+	if c.config.ValkeyPort == 0 {
+		return DefaultValkeyPort
+	}
+	return c.config.ValkeyPort
+}
+
+// apiContext bounds a single Kubernetes API request by Config.APITimeout.
+func (c *Controller) apiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.config.APITimeout
+	if timeout == 0 {
+		timeout = DefaultAPITimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// podIdentity returns the identity pod uses in the leader election. The
+// sidecars of a cluster share their configuration, so it only includes the pod
+// UID if this sidecar knows its own.
+func (c *Controller) podIdentity(pod *corev1.Pod) string {
+	if c.config.PodUID == "" {
+		return pod.Name
+	}
+	return electionIdentity(pod.Name, string(pod.UID))
+}
+
+// hasIdentity reports whether identity names pod, either with its UID or by
+// name alone, as a sidecar deployed without POD_UID would claim the lease.
+func hasIdentity(pod *corev1.Pod, identity string) bool {
+	return identity == pod.Name || identity == electionIdentity(pod.Name, string(pod.UID))
+}
+
+// Leading reports whether this pod currently holds the leader lease.
+func (c *Controller) Leading() bool {
+	return c.state.holdsLease()
+}
+
+// State returns the current state of the Controller.
+func (c *Controller) State() State {
+	return c.state.current()
+}
+
+// Run labels the pod with its cluster, removes any stale role label and fences
+// the local Valkey until its role is confirmed, starts watching the cluster's
+// pods, then runs the reconcile loop and, once the local Valkey is ready, the
+// leader election until ctx is canceled. If the watchdog finds the loop
+// stalled, the lease is released and an error wrapping ErrWatchdogTimeout is
+// returned.
+func (c *Controller) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	err := c.EnsureClusterLabel(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.fenceOnStartup(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.PublishValkeyPort(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.StartInformers(ctx)
+	if err != nil {
+		return err
+	}
+
+	go c.runLoop(ctx)
+	if c.config.WatchdogTimeout > 0 {
+		go c.runWatchdog(ctx, cancel)
+	}
+
+	err = c.waitForValkeyReady(ctx)
+	if err != nil {
+		return runError(ctx)
+	}
+	c.state.fire(TriggerReady)
+
+	for {
+		elector, err := leaderelection.NewLeaderElector(c.leaderElectionConfig())
+		if err != nil {
+			return err
+		}
+		electionCtx, cancelElection := context.WithCancel(ctx)
+		electionDone := make(chan struct{})
+		c.electionMu.Lock()
+		c.cancelElection = cancelElection
+		c.electionDone = electionDone
+		c.electionMu.Unlock()
+		elector.Run(electionCtx)
+		cancelElection()
+		close(electionDone)
+		if c.state.current() == StateDraining {
+			// The pod is shutting down and, if it was the primary, has
+			// ended the election to hand the lease over.
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			return runError(ctx)
+		}
+		c.logger.Info("rejoining leader election")
+	}
+}
+
+// runError returns what Run returns once its context is canceled: the
+// watchdog's error if it stopped Run, or nil.
+func runError(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, ErrWatchdogTimeout) {
+		return cause
+	}
+	return nil
+}
+
+func (c *Controller) leaderElectionConfig() leaderelection.LeaderElectionConfig {
+	var lock resourcelock.Interface = &leaseLock{
+		meta: metav1.ObjectMeta{
+			Name:      c.config.LeaderLeaseName,
+			Namespace: c.config.Namespace,
+		},
+		client:      c.client.CoordinationV1(),
+		identity:    c.Identity(),
+		annotations: c.leaseAnnotations,
+		apiContext:  c.apiContext,
+	}
+	lock = &gatedLock{
+		Interface: lock,
+		gate:      c.promotionGate,
+		observeRenew: func(duration time.Duration) {
+			c.metrics.leaseRenewDuration.Observe(duration.Seconds())
+		},
+		keep: c.keepLease.Load,
+	}
+
+	return leaderelection.LeaderElectionConfig{
+		Name:            c.config.LeaderLeaseName,
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   c.config.LeaseDuration,
+		RenewDeadline:   c.config.RenewDeadline,
+		RetryPeriod:     c.config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: c.OnStartedLeading,
+			OnStoppedLeading: c.OnStoppedLeading,
+			OnNewLeader:      c.OnNewLeader,
+		},
+	}
+}
+
+func (c *Controller) promotionGate(ctx context.Context) error {
+	if c.state.current() == StateDraining {
+		c.logger.Info("not standing for election", slog.Any("reason", ErrDraining))
+		return ErrDraining
+	}
+	err := c.CheckPromotion(ctx)
+	if err != nil {
+		c.logger.Info("not standing for election", slog.Any("reason", err))
+	}
+	return err
+}
+
+// runLoop reconciles the local Valkey according to the current state whenever
+// a reconcile is triggered, and every ReconcileInterval as a safety net. Apart
+// from Shutdown, which waits for the current iteration to finish before handing
+// the primary role over, it is the only goroutine that changes the role of the
+// local Valkey, so a reconcile as a replica can't point it at another primary
+// once it has been promoted.
+func (c *Controller) runLoop(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(c.config.ReconcileInterval):
+		case <-c.reconcileTrigger:
+		case <-ctx.Done():
+			c.logger.InfoContext(ctx, "context canceled")
+			return
+		}
+
+		c.loopWatch.start()
+		switch c.state.current() {
+		case StateStarting, StateCandidate, StateReplica, StateFenced:
+			c.reconcileAsReplica(ctx)
+		case StatePromoting, StatePrimary:
+			c.reconcileAsPrimary()
+		}
+		c.loopWatch.done()
+	}
+}
+
+func (c *Controller) reconcileAsReplica(ctx context.Context) {
+	c.publishReplicationOffset(ctx)
+	start := time.Now()
+	err := c.ReconcileReplica(ctx)
+	c.recordReconcile(RoleReplica, time.Since(start), err)
+	switch {
+	case err == nil:
+		c.state.fire(TriggerReplicating)
+	case errors.Is(err, ErrNoPrimary):
+		c.logger.Warn("no primary pod found, retrying")
+		c.state.fire(TriggerPrimaryLost)
+	default:
+		c.logError("failed to reconcile replica", err)
+	}
+	if err != nil {
+		c.refreshFence(ctx)
+	}
+}
+
+// reconcileAsPrimary reconciles the local Valkey as the primary for as long
+// as the current term lasts. If the lease was lost while the local Valkey was
+// being promoted, it is fenced again.
+func (c *Controller) reconcileAsPrimary() {
+	c.electionMu.Lock()
+	ctx, term := c.leaderCtx, c.leaderTerm
+	c.electionMu.Unlock()
+	if term != c.reconciledTerm {
+		c.reconciledTerm = term
+		c.unhealthyChecks = 0
+		c.datasetChecked = false
+	}
+
+	start := time.Now()
+	err := c.reconcileLeader(ctx)
+	c.recordReconcile(RolePrimary, time.Since(start), err)
+	if err != nil {
+		c.logError("failed to reconcile primary", err)
+	} else if state, ok := c.state.fire(TriggerPromoted); !ok && state == StateFenced {
+		fenceCtx, cancel := context.WithTimeout(context.Background(), c.config.RenewDeadline)
+		c.fenceAfterLeadershipLoss(fenceCtx)
+		cancel()
+	}
+	c.publishReplicationOffset(ctx)
+}
+
+// logError logs err at error level, unless it only says that the local Valkey
+// is unavailable: the client logs that state once when it is entered, so
+// repeating it on every tick would be noise.
+func (c *Controller) logError(msg string, err error) {
+	if errors.Is(err, ErrValkeyUnavailable) {
+		c.logger.Debug(msg, slog.Any("error", err))
+		return
+	}
+	c.logger.Error(msg, slog.Any("error", err))
+}
+
+// ReconcileReplica points the local Valkey at the pod holding the leader lease
+// and labels this pod as a replica.
+func (c *Controller) ReconcileReplica(ctx context.Context) error {
+	primaryPod, err := c.primaryPod()
+	if err != nil {
+		return err
+	}
+	primaryAddress, err := c.replicationAddress(primaryPod)
+	if err != nil {
+		return stepError(StepFindPrimary, err)
+	}
+
+	logger := c.logger.With(slog.String("primary_pod", primaryPod.Name), slog.String("primary_address", primaryAddress))
+	logger.Info("found primary pod")
+	c.recordPrimary(primaryPod.Name, primaryAddress)
+
+	err = c.announceReplicaAddress(ctx)
+	if err != nil {
+		return stepError(StepReplicaOf, err)
+	}
+	primaryPort := PodValkeyPort(primaryPod)
+	err = c.valkey.ReplicaOf(ctx, primaryAddress, primaryPort)
+	if err != nil {
+		return stepError(StepReplicaOf, err)
+	}
+	logger.Info("configured replication")
+
+	err = c.Unfence(ctx)
+	if err != nil {
+		return stepError(StepUnfence, err)
+	}
+
+	err = c.SetRoleLabel(ctx, RoleReplica)
+	if err != nil {
+		return stepError(StepLabelUpdate, err)
+	}
+	logger.Info("updated pod with replica label")
+
+	err = c.checkReplicationLink(ctx, primaryAddress, primaryPort)
+	if err != nil {
+		return stepError(StepReplicationLink, err)
+	}
+
+	return nil
+}
+
+// ReconcilePrimary promotes the local Valkey to primary and labels this pod as
+// the primary.
+func (c *Controller) ReconcilePrimary(ctx context.Context) error {
+	err := c.valkey.PromoteToPrimary(ctx)
+	if err != nil {
+		return stepError(StepPromote, err)
+	}
+	c.logger.Info("promoted to primary")
+	c.recordPrimary(c.config.PodName, c.config.PodIP)
+
+	err = c.Unfence(ctx)
+	if err != nil {
+		return stepError(StepUnfence, err)
+	}
+
+	err = c.SetRoleLabel(ctx, RolePrimary)
+	if err != nil {
+		return stepError(StepLabelUpdate, err)
+	}
+	c.logger.Info("updated pod with primary label")
+
+	return nil
+}
+
+// reconcileLeader health-checks the local Valkey, makes sure on the first
+// reconcile of a term that its dataset is safe to promote, then reconciles it
+// as the primary.
+func (c *Controller) reconcileLeader(ctx context.Context) error {
+	err := c.checkPrimaryHealth(ctx)
+	if err != nil {
+		return stepError(StepHealthCheck, err)
+	}
+	if !c.datasetChecked {
+		err = c.checkPrimaryDataset(ctx)
+		if err != nil {
+			return stepError(StepDatasetCheck, err)
+		}
+		c.datasetChecked = true
+	}
+	return c.ReconcilePrimary(ctx)
+}
+
+// primaryPod returns the pod of the leader lease holder. The lease, not the
+// role label, is the source of truth: labels are only written for Services
+// and can lag behind the election. The holder's pod is skipped while it is
+// terminating or unready, since it is about to give up the lease, and so is
+// this pod, which may still hold a lease it lost track of when its sidecar
+// restarted.
+func (c *Controller) primaryPod() (*corev1.Pod, error) {
+	holder := c.LeaseHolder()
+	if holder == "" || holder == c.Identity() {
+		return nil, stepError(StepFindPrimary, ErrNoPrimary)
+	}
+
+	pod, err := c.podLister.Pods(c.config.Namespace).Get(identityPodName(holder))
+	if apierrors.IsNotFound(err) {
+		return nil, stepError(StepFindPrimary, fmt.Errorf("%w: no pod matches lease holder %s", ErrNoPrimary, holder))
+	}
+	if err != nil {
+		return nil, stepError(StepListPods, err)
+	}
+	if pod.Name == c.config.PodName || !hasIdentity(pod, holder) || !PodReady(pod) {
+		return nil, stepError(StepFindPrimary, fmt.Errorf("%w: no Ready pod matches lease holder %s", ErrNoPrimary, holder))
+	}
+	return pod, nil
+}
+
+// OnStartedLeading starts a new term as the leader and has the loop promote
+// the local Valkey right away. ctx is canceled when the lease is lost.
+func (c *Controller) OnStartedLeading(ctx context.Context) {
+	c.electionMu.Lock()
+	c.leaderCtx = ctx
+	c.leaderTerm++
+	c.electionMu.Unlock()
+
+	_, ok := c.state.fire(TriggerLeaseAcquired)
+	if !ok {
+		return
+	}
+	c.metrics.leaderTransitions.WithLabelValues("started").Inc()
+	c.triggerReconcile("leader lease acquired", c.config.LeaderLeaseName)
+}
+
+// OnStoppedLeading fences the local Valkey if this pod lost the lease it held.
+func (c *Controller) OnStoppedLeading() {
+	c.logger.Info("leader lost")
+	from, ok := c.state.fire(TriggerLeaseLost)
+	if !ok {
+		return
+	}
+	c.metrics.leaderTransitions.WithLabelValues("stopped").Inc()
+	c.recordRole("")
+	eventType := corev1.EventTypeWarning
+	if from == StateDraining {
+		eventType = corev1.EventTypeNormal
+	}
+	c.event(eventType, EventLeaseLost, "Lost leader lease %s; %s", c.config.LeaderLeaseName, c.leaseHolderDescription())
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.RenewDeadline)
+	defer cancel()
+	c.fenceAfterLeadershipLoss(ctx)
+}
+
+// OnNewLeader records the new lease holder, which the reconcile loop follows
+// even if the lease informer has not seen it yet, and unless it is this pod
+// has the loop repoint the local Valkey right away instead of at its next
+// interval.
+func (c *Controller) OnNewLeader(identity string) {
+	c.recordLeaseHolder(identity)
+	self := identity == c.Identity()
+	c.logger.Info("new leader elected", slog.String("identity", identity), slog.Bool("self", self))
+	if !self {
+		c.triggerReconcile("new leader elected", identity)
+	}
+}
@@ -0,0 +1,283 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnsureClusterLabel(t *testing.T) {
+	c, client, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	err := c.EnsureClusterLabel(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := podLabel(t, client, "valkey-0", LabelCluster); got != "valkey" {
+		t.Errorf("cluster label: expected valkey; got %q", got)
+	}
+}
+
+func TestReconcileReplica(t *testing.T) {
+	c, client, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+
+	err := c.ReconcileReplica(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fv.Role() != RoleReplica || fv.primaryHost != "10.0.0.1" || fv.primaryPort != DefaultValkeyPort {
+		t.Errorf("expected replica of 10.0.0.1:%d; got %s of %s:%d", DefaultValkeyPort, fv.role, fv.primaryHost, fv.primaryPort)
+	}
+	if got := podLabel(t, client, "valkey-1", LabelInstanceRole); got != RoleReplica {
+		t.Errorf("role label: expected %s; got %q", RoleReplica, got)
+	}
+}
+
+func TestReconcileReplicaSkipsUnavailablePrimaries(t *testing.T) {
+	terminating := testPod("valkey-2", "10.0.0.3", map[string]string{LabelInstanceRole: RolePrimary})
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	terminating.Finalizers = []string{"test"}
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2",
+		unreadyPod(testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary})),
+		testPod("valkey-1", "10.0.0.2", nil),
+		terminating,
+	)
+
+	err := c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrNoPrimary) {
+		t.Fatalf("expected ErrNoPrimary; got %v", err)
+	}
+	if fv.Role() == RoleReplica {
+		t.Errorf("expected no replication from an unready or terminating primary")
+	}
+}
+
+func TestReconcileReplicaFollowsLease(t *testing.T) {
+	c, client, fv := testController(t, "valkey-2", "10.0.0.3",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+		testPod("valkey-2", "10.0.0.3", nil),
+	)
+
+	// The lease moves before the labels do.
+	setLeaseHolder(t, client, "valkey-1")
+	eventually(t, time.Second, func() bool {
+		err := c.ReconcileReplica(context.Background())
+		return err == nil && fv.primaryHost == "10.0.0.2"
+	})
+	if got := c.Status().ObservedPrimary; got != "valkey-1" {
+		t.Errorf("observed primary: expected valkey-1; got %q", got)
+	}
+}
+
+func TestReconcileReplicaHoldingLease(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", map[string]string{LabelInstanceRole: RolePrimary}),
+	)
+
+	err := c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrNoPrimary) {
+		t.Fatalf("expected ErrNoPrimary while this pod holds the lease; got %v", err)
+	}
+	if len(fv.calls) != 0 {
+		t.Errorf("expected no Valkey calls; got %v", fv.calls)
+	}
+}
+
+func TestReconcileReplicaOtherCluster(t *testing.T) {
+	c, _, _ := testController(t, "valkey-1", "10.0.0.2",
+		testPod("other-0", "10.0.1.1", map[string]string{LabelCluster: "other", LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+
+	err := c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrNoPrimary) {
+		t.Fatalf("expected ErrNoPrimary; got %v", err)
+	}
+}
+
+func TestPrimaryChangeTriggersReconcile(t *testing.T) {
+	c, client, _ := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", nil),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+
+	select {
+	case <-c.reconcileTrigger:
+		t.Fatalf("unexpected reconcile trigger before primary change")
+	default:
+	}
+
+	setLeaseHolder(t, client, "valkey-0")
+
+	select {
+	case <-c.reconcileTrigger:
+	case <-time.After(time.Second):
+		t.Fatalf("expected reconcile trigger after primary change")
+	}
+}
+
+func TestNewLeaderTriggersReconcile(t *testing.T) {
+	c, _, _ := testController(t, "valkey-1", "10.0.0.2", testPod("valkey-1", "10.0.0.2", nil))
+
+	c.OnNewLeader("valkey-1")
+	select {
+	case <-c.reconcileTrigger:
+		t.Fatalf("unexpected reconcile trigger when this pod is the new leader")
+	default:
+	}
+
+	c.OnNewLeader("valkey-0")
+	select {
+	case <-c.reconcileTrigger:
+	default:
+		t.Fatalf("expected reconcile trigger after a new leader was elected")
+	}
+}
+
+func TestReconcileReplicaFollowsNewLeader(t *testing.T) {
+	c, _, fv := testController(t, "valkey-2", "10.0.0.3",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+		testPod("valkey-2", "10.0.0.3", nil),
+	)
+	eventually(t, time.Second, func() bool {
+		return c.LeaseHolder() == "valkey-0"
+	})
+
+	// The election sees valkey-1 take over before the lease informer does.
+	c.OnNewLeader("valkey-1")
+	err := c.ReconcileReplica(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.primaryHost != "10.0.0.2" {
+		t.Errorf("expected replica of the new leader 10.0.0.2; got %s", fv.primaryHost)
+	}
+}
+
+func TestReconcileReplicaNoPrimary(t *testing.T) {
+	c, client, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", nil),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+
+	err := c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrNoPrimary) {
+		t.Fatalf("expected ErrNoPrimary; got %v", err)
+	}
+	if len(fv.calls) != 0 {
+		t.Errorf("expected no Valkey calls; got %v", fv.calls)
+	}
+	if got := podLabel(t, client, "valkey-1", LabelInstanceRole); got != "" {
+		t.Errorf("role label: expected none; got %q", got)
+	}
+}
+
+func TestReconcileReplicaValkeyError(t *testing.T) {
+	c, client, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	fv.err = errors.New("connection refused")
+
+	err := c.ReconcileReplica(context.Background())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if got := podLabel(t, client, "valkey-1", LabelInstanceRole); got != "" {
+		t.Errorf("role label: expected none; got %q", got)
+	}
+}
+
+func TestReconcilePrimary(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.role = RoleReplica
+
+	err := c.ReconcilePrimary(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fv.Role() != RolePrimary {
+		t.Errorf("expected Valkey to be promoted; got %s", fv.Role())
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != RolePrimary {
+		t.Errorf("role label: expected %s; got %q", RolePrimary, got)
+	}
+}
+
+func TestLeaderCallbacks(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.role = RoleReplica
+	go c.runLoop(t.Context())
+	c.state.fire(TriggerReady)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.OnStartedLeading(ctx)
+
+	eventually(t, time.Second, func() bool {
+		return c.Leading() && c.State() == StatePrimary && fv.Role() == RolePrimary
+	})
+	eventually(t, time.Second, func() bool {
+		return podLabel(t, client, "valkey-0", LabelInstanceRole) == RolePrimary
+	})
+
+	cancel()
+	c.OnStoppedLeading()
+	if c.Leading() {
+		t.Errorf("expected controller to stop leading")
+	}
+	if got := c.State(); got != StateFenced {
+		t.Errorf("expected %s; got %s", StateFenced, got)
+	}
+}
+
+func TestStartedLeadingPromotesImmediately(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	c.config.ReconcileInterval = time.Hour
+	fv.role = RoleReplica
+	go c.runLoop(t.Context())
+	c.state.fire(TriggerReady)
+
+	c.OnStartedLeading(t.Context())
+
+	eventually(t, time.Second, func() bool {
+		return fv.Role() == RolePrimary
+	})
+}
+
+func TestRunElectsLeader(t *testing.T) {
+	client := testClientset(testPod("valkey-0", "10.0.0.1", nil))
+	fv := newFakeValkey()
+	fv.role = RoleReplica
+	c := NewController(testConfig("valkey-0", "10.0.0.1"), client, fv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	eventually(t, 5*time.Second, func() bool {
+		return podLabel(t, client, "valkey-0", LabelInstanceRole) == RolePrimary
+	})
+	if got := podLabel(t, client, "valkey-0", LabelCluster); got != "valkey" {
+		t.Errorf("cluster label: expected valkey; got %q", got)
+	}
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// identitySeparator separates the pod name from the pod UID in an election
+// identity. It can't occur in a pod name.
+const identitySeparator = "_"
+
+// electionIdentity returns the identity the pod with the given name and UID
+// uses in the leader election. Unlike the pod IP, the UID is never reused, so
+// a recreated pod or a pod that inherited an old IP is never mistaken for the
+// previous holder. If uid is empty the pod name alone is used.
+func electionIdentity(name string, uid string) string {
+	if uid == "" {
+		return name
+	}
+	return name + identitySeparator + uid
+}
+
+// identityPodName returns the pod name part of an election identity.
+func identityPodName(identity string) string {
+	name, _, _ := strings.Cut(identity, identitySeparator)
+	return name
+}
+
+// leaseLock is a resourcelock.Interface for the leader lease, like
+// resourcelock.LeaseLock, that also writes the annotations returned by
+// annotations whenever this pod acquires or renews the lease. Writing them
+// with the renewal keeps the cached lease current; a separate write would
+// make the next renewal fail on a stale resourceVersion. Every request is
+// bounded by apiContext, since the elector doesn't bound them while it tries
+// to acquire the lease.
+type leaseLock struct {
+	meta        metav1.ObjectMeta
+	client      coordinationv1client.LeasesGetter
+	identity    string
+	annotations func() map[string]string
+	apiContext  func(ctx context.Context) (context.Context, context.CancelFunc)
+	lease       *coordinationv1.Lease
+}
+
+func (l *leaseLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	ctx, cancel := l.apiContext(ctx)
+	defer cancel()
+	lease, err := l.client.Leases(l.meta.Namespace).Get(ctx, l.meta.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	l.lease = lease
+	record := resourcelock.LeaseSpecToLeaderElectionRecord(&lease.Spec)
+	raw, err := json.Marshal(*record)
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, raw, nil
+}
+
+func (l *leaseLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      l.meta.Name,
+			Namespace: l.meta.Namespace,
+		},
+		Spec: resourcelock.LeaderElectionRecordToLeaseSpec(&ler),
+	}
+	l.annotate(lease, ler)
+	ctx, cancel := l.apiContext(ctx)
+	defer cancel()
+	lease, err := l.client.Leases(l.meta.Namespace).Create(ctx, lease, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	l.lease = lease
+	return nil
+}
+
+func (l *leaseLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	if l.lease == nil {
+		return errors.New("lease not initialized, call get or create first")
+	}
+	lease := l.lease.DeepCopy()
+	lease.Spec = resourcelock.LeaderElectionRecordToLeaseSpec(&ler)
+	l.annotate(lease, ler)
+	ctx, cancel := l.apiContext(ctx)
+	defer cancel()
+	lease, err := l.client.Leases(l.meta.Namespace).Update(ctx, lease, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	l.lease = lease
+	return nil
+}
+
+// annotate sets the annotations on lease if ler makes this pod the holder.
+func (l *leaseLock) annotate(lease *coordinationv1.Lease, ler resourcelock.LeaderElectionRecord) {
+	if ler.HolderIdentity != l.identity {
+		return
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	maps.Copy(lease.Annotations, l.annotations())
+}
+
+func (l *leaseLock) RecordEvent(string) {}
+
+func (l *leaseLock) Describe() string {
+	return l.meta.Namespace + "/" + l.meta.Name
+}
+
+func (l *leaseLock) Identity() string {
+	return l.identity
+}
+
+// leaseAnnotations returns the identity, address and Valkey port of this pod
+// and the replication offset the reconcile loop last read, so that replicas
+// and tools can resolve the primary from the lease alone. The identity
+// annotation lets readers discard annotations left behind by a previous
+// holder.
+func (c *Controller) leaseAnnotations() map[string]string {
+	annotations := map[string]string{
+		AnnotationIdentity:   c.Identity(),
+		AnnotationPodIP:      c.config.PodIP,
+		AnnotationValkeyPort: strconv.FormatInt(c.valkeyPort(), 10),
+	}
+	offsetTime := c.localOffsetTime.Load()
+	if offsetTime != 0 {
+		annotations[AnnotationReplicationOffset] = strconv.FormatInt(c.localOffset.Load(), 10)
+		annotations[AnnotationReplicationOffsetTime] = time.Unix(0, offsetTime).UTC().Format(time.RFC3339)
+	}
+	return annotations
+}
@@ -0,0 +1,189 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestElectionIdentity(t *testing.T) {
+	identity := electionIdentity("valkey-0", "2f1c")
+	if identity != "valkey-0_2f1c" {
+		t.Errorf("expected valkey-0_2f1c; got %q", identity)
+	}
+	if got := identityPodName(identity); got != "valkey-0" {
+		t.Errorf("pod name: expected valkey-0; got %q", got)
+	}
+	if got := electionIdentity("valkey-0", ""); got != "valkey-0" {
+		t.Errorf("without UID: expected valkey-0; got %q", got)
+	}
+}
+
+func TestReconcileReplicaFollowsPodUID(t *testing.T) {
+	primary := testPod("valkey-0", "10.0.0.1", nil)
+	primary.UID = types.UID("new")
+	c, client, fv := testController(t, "valkey-1", "10.0.0.2", primary, testPod("valkey-1", "10.0.0.2", nil))
+
+	// valkey-0 was recreated, so the lease still names its previous UID.
+	setLeaseHolder(t, client, "valkey-0_old")
+	eventually(t, time.Second, func() bool {
+		return c.LeaseHolder() == "valkey-0_old"
+	})
+	err := c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrNoPrimary) {
+		t.Fatalf("expected ErrNoPrimary for a previous incarnation of the pod; got %v", err)
+	}
+
+	setLeaseHolder(t, client, "valkey-0_new")
+	eventually(t, time.Second, func() bool {
+		err := c.ReconcileReplica(context.Background())
+		return err == nil && fv.primaryHost == "10.0.0.1"
+	})
+}
+
+func TestReconcileReplicaWithoutPodUID(t *testing.T) {
+	primary := testPod("valkey-0", "10.0.0.1", nil)
+	primary.UID = types.UID("2f1c")
+	replica := testPod("valkey-1", "10.0.0.2", nil)
+	replica.UID = types.UID("9a7e")
+	c, client, fv := testController(t, "valkey-1", "10.0.0.2", primary, replica)
+
+	// Without POD_UID the sidecars claim the lease by pod name alone.
+	if got := c.podIdentity(replica); got != "valkey-1" {
+		t.Errorf("pod identity: expected valkey-1; got %q", got)
+	}
+	setLeaseHolder(t, client, "valkey-0")
+	eventually(t, time.Second, func() bool {
+		err := c.ReconcileReplica(context.Background())
+		return err == nil && fv.primaryHost == "10.0.0.1"
+	})
+	if !c.isPrimaryPod(primary) {
+		t.Errorf("expected valkey-0 to be the primary pod")
+	}
+}
+
+func TestLeaseAnnotations(t *testing.T) {
+	client := testClientset(testPod("valkey-0", "10.0.0.1", nil))
+	conflicts := enforceLeaseVersions(client)
+	fv := newFakeValkey()
+	fv.offset = 42
+	config := testConfig("valkey-0", "10.0.0.1")
+	config.ValkeyPort = 7000
+	c := NewController(config, client, fv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	leaseAnnotations := func() map[string]string {
+		lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		return lease.Annotations
+	}
+	eventually(t, 5*time.Second, func() bool {
+		return leaseAnnotations()[AnnotationReplicationOffset] == "42"
+	})
+	expected := map[string]string{
+		AnnotationIdentity:   "valkey-0",
+		AnnotationPodIP:      "10.0.0.1",
+		AnnotationValkeyPort: "7000",
+	}
+	annotations := leaseAnnotations()
+	for key, value := range expected {
+		if got := annotations[key]; got != value {
+			t.Errorf("%s: expected %q; got %q", key, value, got)
+		}
+	}
+	if annotations[AnnotationReplicationOffsetTime] == "" {
+		t.Errorf("expected %s to be set", AnnotationReplicationOffsetTime)
+	}
+
+	// The offset follows the local Valkey with the renewals, which keep
+	// succeeding on their first attempt.
+	fv.mu.Lock()
+	fv.offset = 43
+	fv.mu.Unlock()
+	eventually(t, 5*time.Second, func() bool {
+		return leaseAnnotations()[AnnotationReplicationOffset] == "43"
+	})
+	time.Sleep(10 * config.RetryPeriod)
+	if n := conflicts.Load(); n != 0 {
+		t.Errorf("expected no conflicting lease updates; got %d", n)
+	}
+	if !c.Leading() {
+		t.Errorf("expected to keep the lease")
+	}
+
+	cancel()
+	<-done
+}
+
+// deadlineLeases records whether the lease requests it forwards carry a
+// deadline.
+type deadlineLeases struct {
+	coordinationv1client.LeaseInterface
+	missing []string
+}
+
+func (l *deadlineLeases) Leases(namespace string) coordinationv1client.LeaseInterface {
+	return l
+}
+
+func (l *deadlineLeases) check(ctx context.Context, verb string) {
+	if _, ok := ctx.Deadline(); !ok {
+		l.missing = append(l.missing, verb)
+	}
+}
+
+func (l *deadlineLeases) Get(ctx context.Context, name string, opts metav1.GetOptions) (*coordinationv1.Lease, error) {
+	l.check(ctx, "get")
+	return l.LeaseInterface.Get(ctx, name, opts)
+}
+
+func (l *deadlineLeases) Create(ctx context.Context, lease *coordinationv1.Lease, opts metav1.CreateOptions) (*coordinationv1.Lease, error) {
+	l.check(ctx, "create")
+	return l.LeaseInterface.Create(ctx, lease, opts)
+}
+
+func (l *deadlineLeases) Update(ctx context.Context, lease *coordinationv1.Lease, opts metav1.UpdateOptions) (*coordinationv1.Lease, error) {
+	l.check(ctx, "update")
+	return l.LeaseInterface.Update(ctx, lease, opts)
+}
+
+func TestLeaseLockAPITimeout(t *testing.T) {
+	c, client, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	leases := &deadlineLeases{LeaseInterface: client.CoordinationV1().Leases("default")}
+	lock := c.leaderElectionConfig().Lock.(*gatedLock).Interface.(*leaseLock)
+	lock.client = leases
+
+	// The elector passes a context without a deadline while it acquires the
+	// lease.
+	ctx := context.Background()
+	_, _, err := lock.Get(ctx)
+	if err == nil {
+		t.Fatalf("expected the lease not to exist yet")
+	}
+	record := resourcelock.LeaderElectionRecord{HolderIdentity: c.Identity(), LeaseDurationSeconds: 2}
+	err = lock.Create(ctx, record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = lock.Update(ctx, record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(leases.missing) != 0 {
+		t.Errorf("expected every lease request to be bounded by the API timeout; unbounded: %v", leases.missing)
+	}
+}
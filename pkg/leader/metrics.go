@@ -0,0 +1,226 @@
+package leader
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+const metricsNamespace = "valkey_leader"
+
+// Reconcile steps used to label reconcile errors.
+const (
+	StepFindPrimary     = "find_primary"
+	StepListPods        = "list_pods"
+	StepReplicaOf       = "replicaof"
+	StepReplicationLink = "replication_link"
+	StepPromote         = "promote"
+	StepLabelUpdate     = "label_update"
+	StepUnfence         = "unfence"
+	StepPublishOffset   = "publish_offset"
+	StepHealthCheck     = "health_check"
+	StepDatasetCheck    = "dataset_check"
+	StepOther           = "other"
+	// StepValkeyUnavailable replaces the step of any error caused by the
+	// local Valkey being unreachable.
+	StepValkeyUnavailable = "valkey_unavailable"
+)
+
+// ReconcileError annotates a reconcile error with the step that failed.
+type ReconcileError struct {
+	Step string
+	Err  error
+}
+
+func (err *ReconcileError) Error() string {
+	return err.Step + ": " + err.Err.Error()
+}
+
+func (err *ReconcileError) Unwrap() error {
+	return err.Err
+}
+
+func stepError(step string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ReconcileError{
+		Step: step,
+		Err:  err,
+	}
+}
+
+// Metrics holds the Prometheus collectors exported by the Controller. It also
+// implements leaderelection.MetricsProvider so that client-go's own leader
+// election metrics end up in the same registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	leaderTransitions      *prometheus.CounterVec
+	role                   *prometheus.GaugeVec
+	reconcileDuration      *prometheus.HistogramVec
+	reconcileErrors        *prometheus.CounterVec
+	leaseRenewDuration     prometheus.Histogram
+	fenceTransitions       *prometheus.CounterVec
+	fenced                 prometheus.Gauge
+	fencedDuration         prometheus.Histogram
+	leaderElectionStatus   *prometheus.GaugeVec
+	leaderElectionSlowpath *prometheus.CounterVec
+
+	// lastReconcileSuccess is the Unix nanosecond timestamp of the last
+	// successful reconcile, or of startup if there hasn't been one yet.
+	lastReconcileSuccess atomic.Int64
+}
+
+func NewMetrics(registry *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		registry: registry,
+		leaderTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader_transitions_total",
+			Help:      "Number of times this pod started or stopped leading.",
+		}, []string{"transition"}),
+		role: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "role",
+			Help:      "Current role of this pod; 1 for the active role, 0 otherwise.",
+		}, []string{"role"}),
+		reconcileDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Duration of reconcile iterations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"role"}),
+		reconcileErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconcile_errors_total",
+			Help:      "Number of failed reconcile iterations by failing step.",
+		}, []string{"step"}),
+		leaseRenewDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "lease_renew_duration_seconds",
+			Help:      "Latency of leader lease renewals.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		fenceTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "fence_transitions_total",
+			Help:      "Number of times the local Valkey was fenced or unfenced.",
+		}, []string{"action"}),
+		fenced: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "fenced",
+			Help:      "Whether the local Valkey is currently fenced.",
+		}),
+		fencedDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "fenced_duration_seconds",
+			Help:      "How long the local Valkey stayed fenced.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+		}),
+		leaderElectionStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader_election_master_status",
+			Help:      "Whether client-go's leader elector considers this pod the leader of the named lease.",
+		}, []string{"name"}),
+		leaderElectionSlowpath: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "leader_election_slowpath_total",
+			Help:      "Number of lease renewals that had to fall back to the slow path.",
+		}, []string{"name"}),
+	}
+	m.lastReconcileSuccess.Store(time.Now().UnixNano())
+
+	registry.MustRegister(
+		m.leaderTransitions,
+		m.role,
+		m.reconcileDuration,
+		m.reconcileErrors,
+		m.leaseRenewDuration,
+		m.fenceTransitions,
+		m.fenced,
+		m.fencedDuration,
+		m.leaderElectionStatus,
+		m.leaderElectionSlowpath,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "seconds_since_last_successful_reconcile",
+			Help:      "Seconds since the last successful reconcile, or since startup if there hasn't been one.",
+		}, func() float64 {
+			return time.Since(time.Unix(0, m.lastReconcileSuccess.Load())).Seconds()
+		}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) observeReconcile(role string, duration time.Duration, err error) {
+	m.reconcileDuration.WithLabelValues(role).Observe(duration.Seconds())
+	if err != nil {
+		m.observeError(err)
+		return
+	}
+	m.lastReconcileSuccess.Store(time.Now().UnixNano())
+}
+
+func (m *Metrics) observeError(err error) {
+	step := StepOther
+	var reconcileErr *ReconcileError
+	if errors.Is(err, ErrValkeyUnavailable) {
+		step = StepValkeyUnavailable
+	} else if errors.As(err, &reconcileErr) {
+		step = reconcileErr.Step
+	}
+	m.reconcileErrors.WithLabelValues(step).Inc()
+}
+
+func (m *Metrics) setRole(role string) {
+	for _, r := range []string{RolePrimary, RoleReplica} {
+		value := 0.0
+		if r == role {
+			value = 1
+		}
+		m.role.WithLabelValues(r).Set(value)
+	}
+}
+
+func (m *Metrics) observeFence() {
+	m.fenceTransitions.WithLabelValues("fence").Inc()
+	m.fenced.Set(1)
+}
+
+func (m *Metrics) observeUnfence(fencedFor time.Duration) {
+	m.fenceTransitions.WithLabelValues("unfence").Inc()
+	m.fenced.Set(0)
+	m.fencedDuration.Observe(fencedFor.Seconds())
+}
+
+// NewLeaderMetric implements leaderelection.MetricsProvider.
+func (m *Metrics) NewLeaderMetric() leaderelection.LeaderMetric {
+	return leaderElectionMetric{m}
+}
+
+type leaderElectionMetric struct {
+	m *Metrics
+}
+
+func (lm leaderElectionMetric) On(name string) {
+	lm.m.leaderElectionStatus.WithLabelValues(name).Set(1)
+}
+
+func (lm leaderElectionMetric) Off(name string) {
+	lm.m.leaderElectionStatus.WithLabelValues(name).Set(0)
+}
+
+func (lm leaderElectionMetric) SlowpathExercised(name string) {
+	lm.m.leaderElectionSlowpath.WithLabelValues(name).Inc()
+}
@@ -0,0 +1,91 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReconcileErrorStep(t *testing.T) {
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	fv.err = errors.New("connection refused")
+
+	err := c.ReconcileReplica(context.Background())
+	var reconcileErr *ReconcileError
+	if !errors.As(err, &reconcileErr) || reconcileErr.Step != StepReplicaOf {
+		t.Fatalf("expected %s step error; got %v", StepReplicaOf, err)
+	}
+	if !errors.Is(err, fv.err) {
+		t.Errorf("expected step error to wrap %v", fv.err)
+	}
+
+	c.recordReconcile(RoleReplica, 0, err)
+	if got := testutil.ToFloat64(c.metrics.reconcileErrors.WithLabelValues(StepReplicaOf)); got != 1 {
+		t.Errorf("reconcile errors: expected 1; got %v", got)
+	}
+}
+
+func TestRoleMetric(t *testing.T) {
+	c, _, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	c.recordReconcile(RolePrimary, 0, c.ReconcilePrimary(context.Background()))
+	if got := testutil.ToFloat64(c.metrics.role.WithLabelValues(RolePrimary)); got != 1 {
+		t.Errorf("primary role: expected 1; got %v", got)
+	}
+	if got := testutil.ToFloat64(c.metrics.role.WithLabelValues(RoleReplica)); got != 0 {
+		t.Errorf("replica role: expected 0; got %v", got)
+	}
+
+	c.recordRole("")
+	if got := testutil.ToFloat64(c.metrics.role.WithLabelValues(RolePrimary)); got != 0 {
+		t.Errorf("primary role after losing it: expected 0; got %v", got)
+	}
+}
+
+func TestFenceMetrics(t *testing.T) {
+	c, _, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	err := c.Fence(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(c.metrics.fenced); got != 1 {
+		t.Errorf("fenced: expected 1; got %v", got)
+	}
+
+	err = c.Unfence(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := testutil.ToFloat64(c.metrics.fenced); got != 0 {
+		t.Errorf("fenced after unfence: expected 0; got %v", got)
+	}
+	if got := testutil.ToFloat64(c.metrics.fenceTransitions.WithLabelValues("unfence")); got != 1 {
+		t.Errorf("unfence transitions: expected 1; got %v", got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	c, _, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	c.metrics.NewLeaderMetric().On("valkey")
+
+	recorder := get(t, c.Handler(), "/metrics")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected %d; got %d", http.StatusOK, recorder.Code)
+	}
+	for _, name := range []string{
+		"valkey_leader_seconds_since_last_successful_reconcile",
+		`valkey_leader_leader_election_master_status{name="valkey"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), name) {
+			t.Errorf("expected %s in metrics output", name)
+		}
+	}
+}
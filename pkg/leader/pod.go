@@ -0,0 +1,183 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/sapslaj/valkey-leader/pkg/ptr"
+)
+
+// EnsureClusterLabel adds the cluster label to the current pod.
+func (c *Controller) EnsureClusterLabel(ctx context.Context) error {
+	return c.setLabel(ctx, LabelCluster, ptr.Of(c.config.ClusterName))
+}
+
+// SetRoleLabel sets the instance role label on the current pod.
+func (c *Controller) SetRoleLabel(ctx context.Context, role string) error {
+	return c.setLabel(ctx, LabelInstanceRole, ptr.Of(role))
+}
+
+// RemoveRoleLabel removes the instance role label from the current pod.
+func (c *Controller) RemoveRoleLabel(ctx context.Context) error {
+	return c.setLabel(ctx, LabelInstanceRole, nil)
+}
+
+// PublishReplicationOffset records the local replication offset and number of
+// keys on the current pod so that peers can compare them during an election.
+func (c *Controller) PublishReplicationOffset(ctx context.Context, offset int64, dbSize int64) error {
+	return c.patchPod(ctx, "annotations", map[string]*string{
+		AnnotationReplicationOffset:     ptr.Of(strconv.FormatInt(offset, 10)),
+		AnnotationDBSize:                ptr.Of(strconv.FormatInt(dbSize, 10)),
+		AnnotationReplicationOffsetTime: ptr.Of(time.Now().UTC().Format(time.RFC3339)),
+	})
+}
+
+// PublishValkeyPort records on the current pod the port the local Valkey
+// listens on, so that replicas connect to the right port.
+func (c *Controller) PublishValkeyPort(ctx context.Context) error {
+	return c.patchPod(ctx, "annotations", map[string]*string{
+		AnnotationValkeyPort: ptr.Of(strconv.FormatInt(c.valkeyPort(), 10)),
+	})
+}
+
+// setLabel sets the label key to value on the current pod, or removes it if
+// value is nil. The pod is only patched if the label differs from what this
+// process last applied.
+func (c *Controller) setLabel(ctx context.Context, key string, value *string) error {
+	c.labelsMu.Lock()
+	defer c.labelsMu.Unlock()
+
+	applied, found := c.appliedLabels[key]
+	if found && sameLabelValue(applied, value) {
+		return nil
+	}
+
+	err := c.patchPod(ctx, "labels", map[string]*string{key: value})
+	if err != nil {
+		return err
+	}
+
+	c.appliedLabels[key] = value
+	return nil
+}
+
+func sameLabelValue(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// patchPod applies a JSON merge patch to the labels or annotations of the
+// current pod, retrying with backoff on conflicts. Keys with a nil value are
+// removed.
+func (c *Controller) patchPod(ctx context.Context, field string, values map[string]*string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			field: values,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return retry.OnError(retry.DefaultBackoff, isRetryablePatchError, func() error {
+		ctx, cancel := c.apiContext(ctx)
+		defer cancel()
+		_, err := c.client.CoreV1().Pods(c.config.Namespace).Patch(
+			ctx,
+			c.config.PodName,
+			types.MergePatchType,
+			patch,
+			metav1.PatchOptions{},
+		)
+		return err
+	})
+}
+
+func isRetryablePatchError(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err)
+}
+
+// PodReady reports whether pod is Ready and not being deleted.
+func PodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// PodValkeyPort returns the port Valkey listens on in pod: the port the
+// sidecar published in the AnnotationValkeyPort annotation, else the
+// container port named "redis", else DefaultValkeyPort.
+func PodValkeyPort(pod *corev1.Pod) int64 {
+	port, err := strconv.ParseInt(pod.Annotations[AnnotationValkeyPort], 10, 32)
+	if err == nil && port > 0 {
+		return port
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == ValkeyPortName {
+				return int64(containerPort.ContainerPort)
+			}
+		}
+	}
+	return DefaultValkeyPort
+}
+
+// PublishedReplicationOffset returns the replication offset a pod last
+// published, and whether it was published more recently than maxAge.
+func PublishedReplicationOffset(pod *corev1.Pod, now time.Time, maxAge time.Duration) (int64, bool) {
+	rawOffset, found := pod.Annotations[AnnotationReplicationOffset]
+	if !found {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(rawOffset, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if !publishedSince(pod, now, maxAge) {
+		return 0, false
+	}
+	return offset, true
+}
+
+// PublishedDBSize returns the number of keys a pod last published, and
+// whether it was published more recently than maxAge.
+func PublishedDBSize(pod *corev1.Pod, now time.Time, maxAge time.Duration) (int64, bool) {
+	rawSize, found := pod.Annotations[AnnotationDBSize]
+	if !found {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(rawSize, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if !publishedSince(pod, now, maxAge) {
+		return 0, false
+	}
+	return size, true
+}
+
+// publishedSince reports whether pod published its replication state more
+// recently than maxAge.
+func publishedSince(pod *corev1.Pod, now time.Time, maxAge time.Duration) bool {
+	publishedAt, err := time.Parse(time.RFC3339, pod.Annotations[AnnotationReplicationOffsetTime])
+	if err != nil {
+		return false
+	}
+	return now.Sub(publishedAt) <= maxAge
+}
@@ -0,0 +1,142 @@
+package leader
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func countActions(client *fake.Clientset, verb string, resource string) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == verb && action.GetResource().Resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSetRoleLabelOnlyPatchesChanges(t *testing.T) {
+	c, client, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	ctx := context.Background()
+
+	for range 3 {
+		err := c.SetRoleLabel(ctx, RoleReplica)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := countActions(client, "patch", "pods"); got != 1 {
+		t.Errorf("expected 1 patch; got %d", got)
+	}
+	if got := countActions(client, "update", "pods"); got != 0 {
+		t.Errorf("expected no updates; got %d", got)
+	}
+
+	err := c.SetRoleLabel(ctx, RolePrimary)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != RolePrimary {
+		t.Errorf("role label: expected %s; got %q", RolePrimary, got)
+	}
+
+	err = c.RemoveRoleLabel(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = c.RemoveRoleLabel(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != "" {
+		t.Errorf("role label: expected none; got %q", got)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelCluster); got != "valkey" {
+		t.Errorf("cluster label: expected valkey; got %q", got)
+	}
+	if got := countActions(client, "patch", "pods"); got != 3 {
+		t.Errorf("expected 3 patches; got %d", got)
+	}
+}
+
+func TestSetRoleLabelRetriesConflicts(t *testing.T) {
+	c, client, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+
+	conflicts := 2
+	client.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "valkey-0", nil)
+	})
+
+	err := c.SetRoleLabel(context.Background(), RoleReplica)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != RoleReplica {
+		t.Errorf("role label: expected %s; got %q", RoleReplica, got)
+	}
+	if got := countActions(client, "patch", "pods"); got != 3 {
+		t.Errorf("expected 3 patch attempts; got %d", got)
+	}
+}
+
+func TestPodValkeyPort(t *testing.T) {
+	withContainerPort := testPod("valkey-0", "10.0.0.1", nil)
+	withContainerPort.Spec.Containers = []corev1.Container{
+		{
+			Name: "valkey",
+			Ports: []corev1.ContainerPort{
+				{Name: "metrics", ContainerPort: 9121},
+				{Name: ValkeyPortName, ContainerPort: 7000},
+			},
+		},
+	}
+	withAnnotation := withContainerPort.DeepCopy()
+	withAnnotation.Annotations = map[string]string{AnnotationValkeyPort: "7001"}
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want int64
+	}{
+		{name: "default", pod: testPod("valkey-0", "10.0.0.1", nil), want: DefaultValkeyPort},
+		{name: "container port", pod: withContainerPort, want: 7000},
+		{name: "annotation", pod: withAnnotation, want: 7001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PodValkeyPort(tt.pod); got != tt.want {
+				t.Errorf("expected %d; got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPublishValkeyPort(t *testing.T) {
+	c, client, _ := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	c.config.ValkeyPort = 7000
+
+	err := c.PublishValkeyPort(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), "valkey-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	if got := PodValkeyPort(pod); got != 7000 {
+		t.Errorf("expected published port 7000; got %d", got)
+	}
+}
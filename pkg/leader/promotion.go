@@ -0,0 +1,225 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// offsetMaxAgeIntervals is how many reconcile intervals a published replication
+// offset stays valid for before the peer that published it is ignored.
+const offsetMaxAgeIntervals = 3
+
+// offsetHeartbeatIntervals is how many reconcile intervals the reconcile loop
+// lets pass before it republishes an otherwise unchanged replication offset,
+// so that it never expires in between.
+const offsetHeartbeatIntervals = offsetMaxAgeIntervals - 1
+
+var (
+	ErrPeerAhead    = errors.New("peer has a higher replication offset")
+	ErrEmptyDataset = errors.New("local dataset is empty while a peer holds data")
+)
+
+// CheckPromotion publishes the local replication offset and number of keys
+// and returns an error if this pod should not try to acquire the leader lease,
+// either because the local Valkey is not ready, its state could not be read,
+// its dataset is empty while a peer's is not, or a healthy peer is further
+// ahead than the configured lag tolerance.
+func (c *Controller) CheckPromotion(ctx context.Context) error {
+	err := c.CheckValkeyReady(ctx)
+	if err != nil {
+		return err
+	}
+
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read replication offset: %w", err)
+	}
+	offset := replication.MasterReplOffset
+	dbSize, err := c.valkey.DBSize(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read dataset size: %w", err)
+	}
+
+	err = c.PublishReplicationOffset(ctx, offset, dbSize)
+	if err != nil {
+		return fmt.Errorf("failed to publish replication offset: %w", err)
+	}
+
+	peers, err := c.podLister.Pods(c.config.Namespace).List(c.clusterSelector())
+	if err != nil {
+		return fmt.Errorf("failed to list peers: %w", err)
+	}
+
+	now := time.Now()
+	err = c.checkDataset(peers, offset, dbSize, now)
+	if err != nil {
+		return err
+	}
+
+	maxAge := offsetMaxAgeIntervals * c.config.ReconcileInterval
+	for _, peer := range peers {
+		if peer.Name == c.config.PodName || !PodReady(peer) {
+			continue
+		}
+		peerOffset, ok := PublishedReplicationOffset(peer, now, maxAge)
+		if !ok {
+			continue
+		}
+		if peerOffset > offset+c.config.PromotionLagTolerance {
+			return fmt.Errorf("%w: %s is at %d, local offset is %d", ErrPeerAhead, peer.Name, peerOffset, offset)
+		}
+	}
+
+	return nil
+}
+
+// CheckDataset returns an error wrapping ErrEmptyDataset if the local Valkey
+// holds no keys while a healthy peer recently published that it holds data at
+// a higher replication offset. Promoting the local Valkey would make every
+// replica full-sync its empty dataset.
+func (c *Controller) CheckDataset(ctx context.Context) error {
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read replication offset: %w", err)
+	}
+	dbSize, err := c.valkey.DBSize(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read dataset size: %w", err)
+	}
+	peers, err := c.podLister.Pods(c.config.Namespace).List(c.clusterSelector())
+	if err != nil {
+		return fmt.Errorf("failed to list peers: %w", err)
+	}
+	return c.checkDataset(peers, replication.MasterReplOffset, dbSize, time.Now())
+}
+
+func (c *Controller) checkDataset(peers []*corev1.Pod, offset int64, dbSize int64, now time.Time) error {
+	if dbSize > 0 {
+		return nil
+	}
+	maxAge := offsetMaxAgeIntervals * c.config.ReconcileInterval
+	for _, peer := range peers {
+		if peer.Name == c.config.PodName || !PodReady(peer) {
+			continue
+		}
+		peerSize, ok := PublishedDBSize(peer, now, maxAge)
+		if !ok || peerSize == 0 {
+			continue
+		}
+		peerOffset, ok := PublishedReplicationOffset(peer, now, maxAge)
+		if ok && peerOffset > offset {
+			return fmt.Errorf("%w: %s holds %d keys at offset %d, local offset is %d", ErrEmptyDataset, peer.Name, peerSize, peerOffset, offset)
+		}
+	}
+	return nil
+}
+
+// checkPrimaryDataset runs CheckDataset before the reconcile loop first
+// promotes the local Valkey in a term. The promotion gate normally keeps an
+// empty pod out of the election, but its view of the peers can be stale; if
+// the check fails here the pod gives the lease back instead of promoting.
+func (c *Controller) checkPrimaryDataset(ctx context.Context) error {
+	err := c.CheckDataset(ctx)
+	if errors.Is(err, ErrEmptyDataset) {
+		c.logger.Error("refusing to promote an empty dataset", slog.Any("error", err))
+		c.event(corev1.EventTypeWarning, EventEmptyDataset, "Refusing to promote and releasing the leader lease: %v", err)
+		c.StepDown()
+	}
+	return err
+}
+
+// publishReplicationOffset caches the local replication offset for the lease
+// annotations and publishes it on the current pod if the dataset became empty
+// or non-empty, or the last publication is about to expire. Patching the pod
+// on every interval would send a watch event to every peer each time; a pod
+// about to stand for election publishes its offset in CheckPromotion anyway.
+func (c *Controller) publishReplicationOffset(ctx context.Context) {
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err != nil {
+		c.logError("failed to read replication offset", err)
+		c.metrics.observeError(stepError(StepPublishOffset, err))
+		return
+	}
+	dbSize, err := c.valkey.DBSize(ctx)
+	if err != nil {
+		c.logError("failed to read dataset size", err)
+		c.metrics.observeError(stepError(StepPublishOffset, err))
+		return
+	}
+	now := time.Now()
+	c.localOffset.Store(replication.MasterReplOffset)
+	c.localOffsetTime.Store(now.UnixNano())
+
+	heartbeat := offsetHeartbeatIntervals * c.config.ReconcileInterval
+	if !c.offsetPublishedAt.IsZero() && (dbSize == 0) == (c.publishedDBSize == 0) && now.Sub(c.offsetPublishedAt) < heartbeat {
+		return
+	}
+	err = c.PublishReplicationOffset(ctx, replication.MasterReplOffset, dbSize)
+	if err != nil {
+		c.logger.Error("failed to publish replication offset", slog.Any("error", err))
+		c.metrics.observeError(stepError(StepPublishOffset, err))
+		return
+	}
+	c.offsetPublishedAt = now
+	c.publishedDBSize = dbSize
+}
+
+// gatedLock wraps a resource lock and consults gate before acquiring a lease
+// that is not already held by this identity. Renewals pass straight through,
+// with renewal latency reported to observeRenew, and so do releases unless
+// keep reports true.
+type gatedLock struct {
+	resourcelock.Interface
+	gate         func(ctx context.Context) error
+	observeRenew func(duration time.Duration)
+	keep         func() bool
+	holder       string
+}
+
+func (l *gatedLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	record, raw, err := l.Interface.Get(ctx)
+	if err == nil {
+		l.holder = record.HolderIdentity
+	}
+	return record, raw, err
+}
+
+func (l *gatedLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	err := l.gate(ctx)
+	if err != nil {
+		return err
+	}
+	err = l.Interface.Create(ctx, ler)
+	if err == nil {
+		l.holder = ler.HolderIdentity
+	}
+	return err
+}
+
+func (l *gatedLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	if ler.HolderIdentity == "" && l.keep != nil && l.keep() {
+		return nil
+	}
+	renewing := ler.HolderIdentity == l.Identity() && l.holder == l.Identity()
+	if ler.HolderIdentity == l.Identity() && !renewing {
+		err := l.gate(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	start := time.Now()
+	err := l.Interface.Update(ctx, ler)
+	if err == nil {
+		if renewing && l.observeRenew != nil {
+			l.observeRenew(time.Since(start))
+		}
+		l.holder = ler.HolderIdentity
+	}
+	return err
+}
@@ -0,0 +1,318 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func peerPod(name string, offset int64, publishedAt time.Time, ready bool) *corev1.Pod {
+	pod := testPod(name, "", map[string]string{LabelCluster: "valkey"})
+	pod.Annotations = map[string]string{
+		AnnotationReplicationOffset:     strconv.FormatInt(offset, 10),
+		AnnotationReplicationOffsetTime: publishedAt.UTC().Format(time.RFC3339),
+	}
+	if !ready {
+		unreadyPod(pod)
+	}
+	return pod
+}
+
+func withDBSize(pod *corev1.Pod, size int64) *corev1.Pod {
+	pod.Annotations[AnnotationDBSize] = strconv.FormatInt(size, 10)
+	return pod
+}
+
+func TestCheckPromotion(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		offset    int64
+		dbSize    int64
+		tolerance int64
+		peers     []*corev1.Pod
+		wantErr   error
+	}{
+		{
+			name:   "no peers",
+			offset: 100,
+		},
+		{
+			name:   "peers behind",
+			offset: 100,
+			peers: []*corev1.Pod{
+				peerPod("valkey-1", 90, now, true),
+				peerPod("valkey-2", 100, now, true),
+			},
+		},
+		{
+			name:   "peer ahead",
+			offset: 100,
+			peers: []*corev1.Pod{
+				peerPod("valkey-1", 90, now, true),
+				peerPod("valkey-2", 150, now, true),
+			},
+			wantErr: ErrPeerAhead,
+		},
+		{
+			name:      "peer ahead within tolerance",
+			offset:    100,
+			tolerance: 50,
+			peers: []*corev1.Pod{
+				peerPod("valkey-1", 150, now, true),
+			},
+		},
+		{
+			name:   "unready peer ahead",
+			offset: 100,
+			peers: []*corev1.Pod{
+				peerPod("valkey-1", 150, now, false),
+			},
+		},
+		{
+			name:   "stale peer ahead",
+			offset: 100,
+			peers: []*corev1.Pod{
+				peerPod("valkey-1", 150, now.Add(-time.Hour), true),
+			},
+		},
+		{
+			name:      "empty while peer holds data",
+			offset:    0,
+			tolerance: 1000,
+			peers: []*corev1.Pod{
+				withDBSize(peerPod("valkey-1", 150, now, true), 10),
+			},
+			wantErr: ErrEmptyDataset,
+		},
+		{
+			name:      "empty like peers",
+			offset:    0,
+			tolerance: 1000,
+			peers: []*corev1.Pod{
+				withDBSize(peerPod("valkey-1", 150, now, true), 0),
+			},
+		},
+		{
+			name:      "holding data behind tolerance",
+			offset:    100,
+			dbSize:    5,
+			tolerance: 1000,
+			peers: []*corev1.Pod{
+				withDBSize(peerPod("valkey-1", 150, now, true), 10),
+			},
+		},
+		{
+			name:      "empty while stale peer holds data",
+			offset:    0,
+			tolerance: 1000,
+			peers: []*corev1.Pod{
+				withDBSize(peerPod("valkey-1", 150, now.Add(-time.Hour), true), 10),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := append([]*corev1.Pod{testPod("valkey-0", "10.0.0.1", map[string]string{LabelCluster: "valkey"})}, tt.peers...)
+			c, client, fv := testController(t, "valkey-0", "10.0.0.1", pods...)
+			c.config.ReconcileInterval = time.Second
+			c.config.PromotionLagTolerance = tt.tolerance
+			fv.offset = tt.offset
+			fv.dbSize = tt.dbSize
+
+			err := c.CheckPromotion(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v; got %v", tt.wantErr, err)
+			}
+
+			pod, err := client.CoreV1().Pods("default").Get(context.Background(), "valkey-0", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get pod: %v", err)
+			}
+			if got := pod.Annotations[AnnotationReplicationOffset]; got != strconv.FormatInt(tt.offset, 10) {
+				t.Errorf("published offset: expected %d; got %q", tt.offset, got)
+			}
+		})
+	}
+}
+
+func TestPrimaryRefusesEmptyDataset(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelCluster: "valkey"}),
+		withDBSize(peerPod("valkey-1", 150, time.Now(), true), 10),
+	)
+	recorder := recordEvents(c)
+	c.config.ReconcileInterval = time.Second
+	fv.role = RoleReplica
+	stepDowns := 0
+	c.cancelElection = func() { stepDowns++ }
+
+	err := c.reconcileLeader(context.Background())
+	if !errors.Is(err, ErrEmptyDataset) {
+		t.Fatalf("expected ErrEmptyDataset; got %v", err)
+	}
+	if stepDowns != 1 {
+		t.Errorf("expected the lease to be released once; got %d", stepDowns)
+	}
+	if fv.Role() != RoleReplica {
+		t.Errorf("expected local Valkey not to be promoted")
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != "" {
+		t.Errorf("expected no role label; got %q", got)
+	}
+	expectEvents(t, recorder, "Warning EmptyDataset")
+
+	fv.dbSize = 10
+	err = c.reconcileLeader(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error once the dataset is populated: %v", err)
+	}
+	if fv.Role() != RolePrimary {
+		t.Errorf("expected local Valkey to be promoted")
+	}
+}
+
+func TestCheckPromotionValkeyError(t *testing.T) {
+	c, _, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	fv.err = errors.New("connection refused")
+
+	err := c.CheckPromotion(context.Background())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestPublishReplicationOffsetThrottled(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1", testPod("valkey-0", "10.0.0.1", nil))
+	c.config.ReconcileInterval = time.Minute
+	fv.offset = 100
+	fv.dbSize = 10
+
+	podPatches := func() int {
+		patches := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "patch" && action.GetResource().Resource == "pods" {
+				patches++
+			}
+		}
+		return patches
+	}
+
+	c.publishReplicationOffset(context.Background())
+	fv.mu.Lock()
+	fv.offset = 200
+	fv.mu.Unlock()
+	c.publishReplicationOffset(context.Background())
+	if got := podPatches(); got != 1 {
+		t.Errorf("expected 1 pod patch for an advancing offset; got %d", got)
+	}
+	if got := c.localOffset.Load(); got != 200 {
+		t.Errorf("expected the lease offset to follow the local Valkey; got %d", got)
+	}
+
+	fv.mu.Lock()
+	fv.dbSize = 0
+	fv.mu.Unlock()
+	c.publishReplicationOffset(context.Background())
+	if got := podPatches(); got != 2 {
+		t.Errorf("expected the emptied dataset to be published; got %d patches", got)
+	}
+
+	c.offsetPublishedAt = c.offsetPublishedAt.Add(-offsetHeartbeatIntervals * c.config.ReconcileInterval)
+	c.publishReplicationOffset(context.Background())
+	if got := podPatches(); got != 3 {
+		t.Errorf("expected the offset to be republished before it expires; got %d patches", got)
+	}
+}
+
+// fakeLock is an in-memory resourcelock.Interface.
+type fakeLock struct {
+	identity string
+	record   *resourcelock.LeaderElectionRecord
+}
+
+func (l *fakeLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	if l.record == nil {
+		return nil, nil, errors.New("not found")
+	}
+	record := *l.record
+	return &record, nil, nil
+}
+
+func (l *fakeLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.record = &ler
+	return nil
+}
+
+func (l *fakeLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.record = &ler
+	return nil
+}
+
+func (l *fakeLock) RecordEvent(string) {}
+
+func (l *fakeLock) Identity() string {
+	return l.identity
+}
+
+func (l *fakeLock) Describe() string {
+	return "fake"
+}
+
+func TestGatedLock(t *testing.T) {
+	ctx := context.Background()
+	gateErr := errors.New("not eligible")
+	gateCalls := 0
+	lock := &gatedLock{
+		Interface: &fakeLock{
+			identity: "self",
+			record:   &resourcelock.LeaderElectionRecord{HolderIdentity: "other"},
+		},
+		gate: func(ctx context.Context) error {
+			gateCalls++
+			return gateErr
+		},
+	}
+
+	// Acquiring a lease held by someone else is gated.
+	_, _, err := lock.Get(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = lock.Update(ctx, resourcelock.LeaderElectionRecord{HolderIdentity: "self"})
+	if !errors.Is(err, gateErr) {
+		t.Fatalf("expected gate error; got %v", err)
+	}
+
+	// Once the gate opens, the lease is acquired.
+	gateErr = nil
+	err = lock.Update(ctx, resourcelock.LeaderElectionRecord{HolderIdentity: "self"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gateCalls != 2 {
+		t.Errorf("expected 2 gate calls; got %d", gateCalls)
+	}
+
+	// Renewals and releases are not gated.
+	gateErr = errors.New("not eligible")
+	err = lock.Update(ctx, resourcelock.LeaderElectionRecord{HolderIdentity: "self"})
+	if err != nil {
+		t.Fatalf("unexpected error on renew: %v", err)
+	}
+	err = lock.Update(ctx, resourcelock.LeaderElectionRecord{})
+	if err != nil {
+		t.Fatalf("unexpected error on release: %v", err)
+	}
+	if gateCalls != 2 {
+		t.Errorf("expected 2 gate calls; got %d", gateCalls)
+	}
+}
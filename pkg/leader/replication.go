@@ -0,0 +1,93 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Ways replicas can address their primary.
+const (
+	// ReplicationAddressIP points replicas at the primary pod's IP.
+	ReplicationAddressIP = "ip"
+	// ReplicationAddressDNS points replicas at the primary pod's stable name
+	// under the headless Service, <pod>.<service>.<namespace>.svc, and makes
+	// replicas announce their own name the same way.
+	ReplicationAddressDNS = "dns"
+)
+
+// replicationAddress returns the address replicas use to reach pod.
+func (c *Controller) replicationAddress(pod *corev1.Pod) (string, error) {
+	if c.config.ReplicationAddressMode == ReplicationAddressDNS {
+		return c.podDNSName(pod.Name), nil
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("pod %s has no IP address", pod.Name)
+	}
+	return pod.Status.PodIP, nil
+}
+
+func (c *Controller) podDNSName(podName string) string {
+	return podName + "." + c.config.ServiceName + "." + c.config.Namespace + ".svc"
+}
+
+// announceReplicaAddress makes the local Valkey announce its stable name to
+// the primary, so that INFO replication and FAILOVER TO use it as well.
+func (c *Controller) announceReplicaAddress(ctx context.Context) error {
+	if c.config.ReplicationAddressMode != ReplicationAddressDNS {
+		return nil
+	}
+	return c.valkey.AnnounceReplicaAddress(ctx, c.podDNSName(c.config.PodName), c.valkeyPort())
+}
+
+// replicaPod returns the pod behind a replica reported by INFO replication.
+func (c *Controller) replicaPod(replica ReplicaInfo) (*corev1.Pod, error) {
+	if c.config.ReplicationAddressMode == ReplicationAddressDNS {
+		podName, _, _ := strings.Cut(replica.IP, ".")
+		return c.podLister.Pods(c.config.Namespace).Get(podName)
+	}
+	pods, err := c.podLister.Pods(c.config.Namespace).List(c.clusterSelector())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if pod.Status.PodIP == replica.IP {
+			return pod, nil
+		}
+	}
+	return nil, errors.New("no pod found for replica " + replica.IP)
+}
+
+// checkReplicationLink verifies that the local Valkey's link to the primary
+// at host:port is up. The link needs a moment to come up after REPLICAOF, so it is
+// only reported as broken once it has been down for ReplicationLinkTimeout.
+// If the primary rejects the replication credentials the error wraps
+// ErrReplicationAuth.
+func (c *Controller) checkReplicationLink(ctx context.Context, host string, port int64) error {
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if replication.MasterLinkStatus == "up" || host != c.linkPrimary {
+		c.linkPrimary = host
+		c.linkDownSince = now
+	}
+	if replication.MasterLinkStatus == "up" {
+		return nil
+	}
+	if now.Sub(c.linkDownSince) < c.config.ReplicationLinkTimeout {
+		return nil
+	}
+
+	err = c.valkey.CheckPrimaryAuth(ctx, host, port)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("replication link to %s:%d is %s", host, port, replication.MasterLinkStatus)
+}
@@ -0,0 +1,93 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReplicationLinkDown(t *testing.T) {
+	c, client, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	c.config.ReplicationLinkTimeout = time.Hour
+	fv.linkStatus = "down"
+
+	err := c.ReconcileReplica(context.Background())
+	if err != nil {
+		t.Fatalf("expected link to get time to come up; got %v", err)
+	}
+
+	c.config.ReplicationLinkTimeout = 0
+	err = c.ReconcileReplica(context.Background())
+	var reconcileErr *ReconcileError
+	if !errors.As(err, &reconcileErr) || reconcileErr.Step != StepReplicationLink {
+		t.Fatalf("expected %s step error; got %v", StepReplicationLink, err)
+	}
+	if errors.Is(err, ErrReplicationAuth) {
+		t.Errorf("expected no auth error; got %v", err)
+	}
+	if got := podLabel(t, client, "valkey-1", LabelInstanceRole); got != RoleReplica {
+		t.Errorf("role label: expected %s; got %q", RoleReplica, got)
+	}
+}
+
+func TestReplicationLinkAuthError(t *testing.T) {
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	fv.linkStatus = "down"
+	fv.authErr = fmt.Errorf("%w: WRONGPASS invalid username-password pair", ErrReplicationAuth)
+
+	err := c.ReconcileReplica(context.Background())
+	if !errors.Is(err, ErrReplicationAuth) {
+		t.Fatalf("expected ErrReplicationAuth; got %v", err)
+	}
+
+	fv.linkStatus = "up"
+	err = c.ReconcileReplica(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error once the link is up: %v", err)
+	}
+}
+
+func TestReconcileReplicaDNS(t *testing.T) {
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	c.config.ReplicationAddressMode = ReplicationAddressDNS
+
+	err := c.ReconcileReplica(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fv.primaryHost != "valkey-0.valkey-headless.default.svc" {
+		t.Errorf("expected replica of valkey-0 by name; got %s", fv.primaryHost)
+	}
+	if fv.announced != "valkey-1.valkey-headless.default.svc:6379" {
+		t.Errorf("expected to announce valkey-1 by name; got %q", fv.announced)
+	}
+	if got := c.Status().ObservedPrimaryAddress; got != "valkey-0.valkey-headless.default.svc" {
+		t.Errorf("observed primary address: expected valkey-0 by name; got %q", got)
+	}
+}
+
+func TestReconcileReplicaPrimaryPort(t *testing.T) {
+	primary := testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary})
+	primary.Annotations = map[string]string{AnnotationValkeyPort: "7000"}
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2", primary, testPod("valkey-1", "10.0.0.2", nil))
+
+	err := c.ReconcileReplica(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.primaryHost != "10.0.0.1" || fv.primaryPort != 7000 {
+		t.Errorf("expected replica of 10.0.0.1:7000; got %s:%d", fv.primaryHost, fv.primaryPort)
+	}
+}
@@ -0,0 +1,164 @@
+package leader
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// State is the role the Controller currently plays in the cluster.
+type State string
+
+const (
+	// StateStarting is the initial state, until the local Valkey is ready to
+	// join the leader election or replicates from the primary.
+	StateStarting State = "Starting"
+	// StateCandidate stands for election without replicating from a primary.
+	StateCandidate State = "Candidate"
+	// StateReplica replicates from the pod holding the leader lease.
+	StateReplica State = "Replica"
+	// StatePromoting holds the leader lease but has not yet promoted the local
+	// Valkey.
+	StatePromoting State = "Promoting"
+	// StatePrimary holds the leader lease and has promoted the local Valkey.
+	StatePrimary State = "Primary"
+	// StateFenced lost the leader lease and keeps writes paused until it
+	// replicates from the new primary.
+	StateFenced State = "Fenced"
+	// StateDraining is shutting down and no longer reconciles. It is final.
+	StateDraining State = "Draining"
+)
+
+// Trigger is something that happened which may move the Controller to
+// another State.
+type Trigger string
+
+const (
+	// TriggerReady fires once the local Valkey is ready to join the election.
+	TriggerReady Trigger = "ready"
+	// TriggerReplicating fires after a successful reconcile as a replica.
+	TriggerReplicating Trigger = "replicating"
+	// TriggerPrimaryLost fires when a reconcile as a replica finds no primary.
+	TriggerPrimaryLost Trigger = "primary_lost"
+	// TriggerLeaseAcquired fires when this pod acquires the leader lease.
+	TriggerLeaseAcquired Trigger = "lease_acquired"
+	// TriggerPromoted fires after a successful reconcile as the primary.
+	TriggerPromoted Trigger = "promoted"
+	// TriggerLeaseLost fires when this pod loses or releases the leader
+	// lease.
+	TriggerLeaseLost Trigger = "lease_lost"
+	// TriggerDrain fires when the sidecar starts shutting down.
+	TriggerDrain Trigger = "drain"
+)
+
+// transitions maps each state to the state every trigger that applies in it
+// leads to. Triggers missing from a state's map are ignored in that state.
+var transitions = map[State]map[Trigger]State{
+	StateStarting: {
+		TriggerReady:       StateCandidate,
+		TriggerReplicating: StateReplica,
+		TriggerDrain:       StateDraining,
+	},
+	StateCandidate: {
+		TriggerReplicating:   StateReplica,
+		TriggerLeaseAcquired: StatePromoting,
+		TriggerDrain:         StateDraining,
+	},
+	StateReplica: {
+		TriggerReady:         StateReplica,
+		TriggerReplicating:   StateReplica,
+		TriggerPrimaryLost:   StateCandidate,
+		TriggerLeaseAcquired: StatePromoting,
+		TriggerDrain:         StateDraining,
+	},
+	StatePromoting: {
+		TriggerPromoted:  StatePrimary,
+		TriggerLeaseLost: StateFenced,
+		TriggerDrain:     StateDraining,
+	},
+	StatePrimary: {
+		TriggerPromoted:  StatePrimary,
+		TriggerLeaseLost: StateFenced,
+		TriggerDrain:     StateDraining,
+	},
+	StateFenced: {
+		TriggerReplicating:   StateReplica,
+		TriggerLeaseAcquired: StatePromoting,
+		TriggerDrain:         StateDraining,
+	},
+	StateDraining: {
+		// A draining primary still holds the lease until it has handed it
+		// over or released it.
+		TriggerLeaseLost: StateDraining,
+	},
+}
+
+// nextState returns the state trigger leads to from state, and false if
+// trigger does not apply in state.
+func nextState(state State, trigger Trigger) (State, bool) {
+	next, ok := transitions[state][trigger]
+	return next, ok
+}
+
+// stateMachine holds the Controller's current State and whether it holds the
+// leader lease, and logs every transition.
+type stateMachine struct {
+	mu    sync.Mutex
+	state State
+	// leaseHeld is set on entering StatePromoting and cleared by
+	// TriggerLeaseLost, which only applies while it is set.
+	leaseHeld bool
+	logger    *slog.Logger
+}
+
+func newStateMachine(logger *slog.Logger) *stateMachine {
+	return &stateMachine{
+		state:  StateStarting,
+		logger: logger,
+	}
+}
+
+func (m *stateMachine) current() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *stateMachine) holdsLease() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.leaseHeld
+}
+
+// fire applies trigger and returns the state it was applied in, and whether
+// it applied. A trigger that does not apply leaves the state unchanged.
+func (m *stateMachine) fire(trigger Trigger) (State, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := m.state
+	to, ok := nextState(from, trigger)
+	if trigger == TriggerLeaseLost && !m.leaseHeld {
+		ok = false
+	}
+	if !ok {
+		m.logger.Debug("ignoring trigger", slog.String("state", string(from)), slog.String("trigger", string(trigger)))
+		return from, false
+	}
+
+	switch {
+	case to == StatePromoting:
+		m.leaseHeld = true
+	case trigger == TriggerLeaseLost:
+		m.leaseHeld = false
+	}
+	m.state = to
+	if to != from {
+		m.logger.Info(
+			"state changed",
+			slog.String("from", string(from)),
+			slog.String("to", string(to)),
+			slog.String("trigger", string(trigger)),
+		)
+	}
+	return from, true
+}
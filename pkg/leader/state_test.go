@@ -0,0 +1,155 @@
+package leader
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNextState(t *testing.T) {
+	tests := []struct {
+		state   State
+		trigger Trigger
+		next    State
+		ok      bool
+	}{
+		{StateStarting, TriggerReady, StateCandidate, true},
+		{StateStarting, TriggerReplicating, StateReplica, true},
+		{StateStarting, TriggerLeaseAcquired, "", false},
+		{StateStarting, TriggerDrain, StateDraining, true},
+		{StateCandidate, TriggerReplicating, StateReplica, true},
+		{StateCandidate, TriggerLeaseAcquired, StatePromoting, true},
+		{StateCandidate, TriggerPromoted, "", false},
+		{StateReplica, TriggerReady, StateReplica, true},
+		{StateReplica, TriggerPrimaryLost, StateCandidate, true},
+		{StateReplica, TriggerLeaseAcquired, StatePromoting, true},
+		{StatePromoting, TriggerReplicating, "", false},
+		{StatePromoting, TriggerPromoted, StatePrimary, true},
+		{StatePromoting, TriggerLeaseLost, StateFenced, true},
+		{StatePrimary, TriggerReplicating, "", false},
+		{StatePrimary, TriggerPromoted, StatePrimary, true},
+		{StatePrimary, TriggerLeaseLost, StateFenced, true},
+		{StatePrimary, TriggerDrain, StateDraining, true},
+		{StateFenced, TriggerPromoted, "", false},
+		{StateFenced, TriggerPrimaryLost, "", false},
+		{StateFenced, TriggerReplicating, StateReplica, true},
+		{StateFenced, TriggerLeaseAcquired, StatePromoting, true},
+		{StateDraining, TriggerLeaseLost, StateDraining, true},
+		{StateDraining, TriggerLeaseAcquired, "", false},
+		{StateDraining, TriggerReplicating, "", false},
+		{StateDraining, TriggerDrain, "", false},
+	}
+	for _, test := range tests {
+		next, ok := nextState(test.state, test.trigger)
+		if next != test.next || ok != test.ok {
+			t.Errorf("%s on %s: expected %q, %v; got %q, %v", test.state, test.trigger, test.next, test.ok, next, ok)
+		}
+	}
+}
+
+func TestStateMachineLease(t *testing.T) {
+	tests := []struct {
+		name     string
+		triggers []Trigger
+		state    State
+		leading  bool
+	}{
+		{
+			name:     "never leading",
+			triggers: []Trigger{TriggerReady, TriggerLeaseLost},
+			state:    StateCandidate,
+		},
+		{
+			name:     "primary",
+			triggers: []Trigger{TriggerReady, TriggerLeaseAcquired, TriggerPromoted},
+			state:    StatePrimary,
+			leading:  true,
+		},
+		{
+			name:     "lost lease",
+			triggers: []Trigger{TriggerReady, TriggerLeaseAcquired, TriggerPromoted, TriggerLeaseLost},
+			state:    StateFenced,
+		},
+		{
+			name:     "draining primary",
+			triggers: []Trigger{TriggerReady, TriggerLeaseAcquired, TriggerPromoted, TriggerDrain},
+			state:    StateDraining,
+			leading:  true,
+		},
+		{
+			name:     "draining primary handed over",
+			triggers: []Trigger{TriggerReady, TriggerLeaseAcquired, TriggerPromoted, TriggerDrain, TriggerLeaseLost},
+			state:    StateDraining,
+		},
+		{
+			name:     "draining replica",
+			triggers: []Trigger{TriggerReplicating, TriggerDrain, TriggerLeaseAcquired},
+			state:    StateDraining,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newStateMachine(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			for _, trigger := range test.triggers {
+				m.fire(trigger)
+			}
+			if got := m.current(); got != test.state {
+				t.Errorf("state: expected %s; got %s", test.state, got)
+			}
+			if got := m.holdsLease(); got != test.leading {
+				t.Errorf("holds lease: expected %v; got %v", test.leading, got)
+			}
+		})
+	}
+}
+
+func TestStateMachineIgnoresLeaseLostWithoutLease(t *testing.T) {
+	m := newStateMachine(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.fire(TriggerDrain)
+
+	from, ok := m.fire(TriggerLeaseLost)
+	if ok {
+		t.Errorf("expected lease lost to be ignored without a lease")
+	}
+	if from != StateDraining {
+		t.Errorf("expected %s; got %s", StateDraining, from)
+	}
+}
+
+func TestLoopDoesNotReplicateAfterPromotion(t *testing.T) {
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", nil),
+	)
+	go c.runLoop(t.Context())
+	c.state.fire(TriggerReady)
+	eventually(t, time.Second, func() bool {
+		return c.State() == StateReplica
+	})
+
+	// The lease moves to this pod while the old primary still looks healthy.
+	c.OnStartedLeading(t.Context())
+	eventually(t, time.Second, func() bool {
+		return c.State() == StatePrimary
+	})
+	for range 5 {
+		c.triggerReconcile("test", "valkey-1")
+		time.Sleep(2 * c.config.ReconcileInterval)
+	}
+
+	fv.mu.Lock()
+	calls := slices.Clone(fv.calls)
+	fv.mu.Unlock()
+	promoted := slices.Index(calls, "REPLICAOF NO ONE")
+	if promoted < 0 {
+		t.Fatalf("expected Valkey to be promoted; got %v", calls)
+	}
+	if slices.Contains(calls[promoted:], "REPLICAOF") {
+		t.Errorf("expected no REPLICAOF after promotion; got %v", calls)
+	}
+	if fv.Role() != RolePrimary {
+		t.Errorf("expected Valkey to stay primary; got %s", fv.Role())
+	}
+}
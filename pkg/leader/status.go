@@ -0,0 +1,81 @@
+package leader
+
+import (
+	"time"
+)
+
+// Status is a snapshot of what the Controller believes about itself and the
+// cluster.
+type Status struct {
+	Role                   string     `json:"role"`
+	State                  State      `json:"state"`
+	Leading                bool       `json:"leading"`
+	Fenced                 bool       `json:"fenced"`
+	LeaseHolder            string     `json:"leaseHolder"`
+	ObservedPrimary        string     `json:"observedPrimary"`
+	ObservedPrimaryAddress string     `json:"observedPrimaryAddress"`
+	LastReconcileTime      *time.Time `json:"lastReconcileTime,omitempty"`
+	LastReconcileError     string     `json:"lastReconcileError,omitempty"`
+}
+
+// Status returns the current status of the Controller.
+func (c *Controller) Status() Status {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	status := c.status
+	status.State = c.State()
+	status.Leading = c.Leading()
+	status.Fenced = c.Fenced()
+	return status
+}
+
+func (c *Controller) recordReconcile(role string, duration time.Duration, err error) {
+	c.metrics.observeReconcile(role, duration, err)
+
+	c.statusMu.Lock()
+	previous := c.status
+	previousSource := c.replicationSource
+	now := time.Now()
+	c.status.LastReconcileTime = &now
+	if err != nil {
+		c.status.LastReconcileError = err.Error()
+	} else {
+		c.status.LastReconcileError = ""
+		c.status.Role = role
+		c.replicationSource = ""
+		if role == RoleReplica {
+			c.replicationSource = c.status.ObservedPrimaryAddress
+		}
+	}
+	current := c.status
+	c.statusMu.Unlock()
+
+	if err == nil {
+		c.metrics.setRole(role)
+	}
+	c.reconcileEvents(role, previous, previousSource, current, err)
+}
+
+func (c *Controller) recordRole(role string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.status.Role = role
+	c.metrics.setRole(role)
+}
+
+func (c *Controller) recordPrimary(name string, address string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.status.ObservedPrimary = name
+	c.status.ObservedPrimaryAddress = address
+}
+
+func (c *Controller) recordLeaseHolder(identity string) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	c.status.LeaseHolder = identity
+}
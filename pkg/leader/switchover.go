@@ -0,0 +1,238 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/sapslaj/valkey-leader/pkg/ptr"
+)
+
+// switchoverPollInterval is how often the reconcile loop and the local
+// replication state are checked while waiting for a switchover to proceed.
+const switchoverPollInterval = 100 * time.Millisecond
+
+var ErrNoSwitchoverTarget = errors.New("no replica qualifies for switchover")
+
+// Shutdown moves the Controller to StateDraining, which stops the reconcile
+// loop, and if this pod is the primary hands the primary role and the leader
+// lease over to the best-synced replica once the loop has finished its current
+// iteration. If no replica qualifies or the failover fails the lease is left
+// to be released as usual once the election context is canceled; if only the
+// transfer fails, Switchover releases it.
+func (c *Controller) Shutdown(ctx context.Context) error {
+	from, _ := c.state.fire(TriggerDrain)
+	if from != StatePromoting && from != StatePrimary {
+		return nil
+	}
+
+	// An iteration that started before the drain may still be promoting the
+	// local Valkey or labeling the pod primary, which would undo the
+	// handover.
+	err := c.waitForLoop(ctx)
+	if err != nil {
+		return fmt.Errorf("switchover failed: %w", err)
+	}
+
+	err = c.Switchover(ctx)
+	if err != nil {
+		return fmt.Errorf("switchover failed: %w", err)
+	}
+	return nil
+}
+
+// Switchover runs FAILOVER against the best-synced replica, waits for the
+// local Valkey to become its replica, then ends the leader election without
+// releasing the lease and transfers the lease to it.
+func (c *Controller) Switchover(ctx context.Context) error {
+	replication, err := c.valkey.ReplicationInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	target, ok := SwitchoverTarget(replication.Replicas)
+	if !ok {
+		return ErrNoSwitchoverTarget
+	}
+	targetPod, err := c.replicaPod(target)
+	if err != nil {
+		return err
+	}
+
+	logger := c.logger.With(
+		slog.String("target_ip", target.IP),
+		slog.Int64("target_port", target.Port),
+		slog.Int64("target_offset", target.Offset),
+	)
+	logger.Info("starting switchover")
+
+	// Drop the primary label first so that nothing points the target back at
+	// this pod while the handover is in flight.
+	err = c.SetRoleLabel(ctx, RoleReplica)
+	if err != nil {
+		return err
+	}
+
+	err = c.valkey.Failover(ctx, target.IP, target.Port, c.config.SwitchoverTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = c.waitForFailover(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Info("failover completed")
+	c.event(corev1.EventTypeNormal, EventDemoted, "Handed primary over to %s:%d for shutdown", target.IP, target.Port)
+
+	// Releasing the lease on the way out would overwrite the transfer.
+	err = c.endElection(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.TransferLease(ctx, c.podIdentity(targetPod))
+	if err != nil {
+		// Give the lease up as the elector would have, so the remaining pods
+		// don't have to wait for it to expire.
+		c.keepLease.Store(false)
+		releaseErr := c.ReleaseLease(ctx)
+		if releaseErr != nil {
+			logger.Error("failed to release leader lease", slog.Any("error", releaseErr))
+		}
+		return err
+	}
+	logger.Info("transferred leader lease")
+
+	return nil
+}
+
+// SwitchoverTarget returns the online replica with the highest replication
+// offset.
+func SwitchoverTarget(replicas []ReplicaInfo) (ReplicaInfo, bool) {
+	var target ReplicaInfo
+	found := false
+	for _, replica := range replicas {
+		if replica.State != "online" {
+			continue
+		}
+		if !found || replica.Offset > target.Offset {
+			target = replica
+			found = true
+		}
+	}
+	return target, found
+}
+
+// endElection ends the current leader election, if any, without releasing
+// the lease and waits for the elector to return.
+func (c *Controller) endElection(ctx context.Context) error {
+	c.keepLease.Store(true)
+	c.electionMu.Lock()
+	cancel, done := c.cancelElection, c.electionDone
+	c.electionMu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitForLoop waits for the reconcile loop to finish its current iteration,
+// if any.
+func (c *Controller) waitForLoop(ctx context.Context) error {
+	for c.loopWatch.busyFor(time.Now()) != 0 {
+		select {
+		case <-time.After(switchoverPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (c *Controller) waitForFailover(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.SwitchoverTimeout+time.Second)
+	defer cancel()
+
+	for {
+		replication, err := c.valkey.ReplicationInfo(ctx)
+		if err == nil && replication.Role == "slave" {
+			return nil
+		}
+		if err == nil && replication.MasterFailoverState == "no-failover" {
+			return errors.New("failover was aborted")
+		}
+
+		select {
+		case <-time.After(switchoverPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TransferLease hands the leader lease held by this pod directly to identity.
+// The elector of the receiving pod sees itself as the holder on its next
+// renewal and takes over without waiting for the lease to expire.
+func (c *Controller) TransferLease(ctx context.Context, identity string) error {
+	return c.updateHeldLease(ctx, func(lease *coordinationv1.Lease, now metav1.MicroTime) {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.HolderIdentity = &identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	})
+}
+
+// ReleaseLease gives up the leader lease held by this pod the way the elector
+// does when its context is canceled, so that another pod can acquire it right
+// away.
+func (c *Controller) ReleaseLease(ctx context.Context) error {
+	return c.updateHeldLease(ctx, func(lease *coordinationv1.Lease, now metav1.MicroTime) {
+		lease.Spec.HolderIdentity = ptr.Of("")
+		lease.Spec.LeaseDurationSeconds = ptr.Of(int32(1))
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+	})
+}
+
+// updateHeldLease applies update to the leader lease if this pod holds it,
+// retrying on conflicts.
+func (c *Controller) updateHeldLease(ctx context.Context, update func(lease *coordinationv1.Lease, now metav1.MicroTime)) error {
+	leases := c.client.CoordinationV1().Leases(c.config.Namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		getCtx, cancel := c.apiContext(ctx)
+		defer cancel()
+		lease, err := leases.Get(getCtx, c.config.LeaderLeaseName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != c.Identity() {
+			return errors.New("lease is not held by this pod")
+		}
+		update(lease, metav1.NewMicroTime(time.Now()))
+
+		updateCtx, cancel := c.apiContext(ctx)
+		defer cancel()
+		_, err = leases.Update(updateCtx, lease, metav1.UpdateOptions{})
+		return err
+	})
+}
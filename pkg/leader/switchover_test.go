@@ -0,0 +1,274 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/sapslaj/valkey-leader/pkg/ptr"
+)
+
+func TestSwitchoverTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas []ReplicaInfo
+		wantIP   string
+		wantOK   bool
+	}{
+		{
+			name: "no replicas",
+		},
+		{
+			name: "only offline replicas",
+			replicas: []ReplicaInfo{
+				{IP: "10.0.0.2", State: "wait_bgsave", Offset: 100},
+			},
+		},
+		{
+			name: "highest online offset wins",
+			replicas: []ReplicaInfo{
+				{IP: "10.0.0.2", State: "online", Offset: 90},
+				{IP: "10.0.0.3", State: "online", Offset: 100},
+				{IP: "10.0.0.4", State: "send_bulk", Offset: 200},
+			},
+			wantIP: "10.0.0.3",
+			wantOK: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, ok := SwitchoverTarget(tt.replicas)
+			if ok != tt.wantOK || target.IP != tt.wantIP {
+				t.Errorf("expected %q, %v; got %q, %v", tt.wantIP, tt.wantOK, target.IP, ok)
+			}
+		})
+	}
+}
+
+func TestShutdownSwitchover(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", map[string]string{LabelInstanceRole: RoleReplica}),
+		testPod("valkey-2", "10.0.0.3", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	fv.replicas = []ReplicaInfo{
+		{IP: "10.0.0.2", Port: 6379, State: "online", Offset: 90},
+		{IP: "10.0.0.3", Port: 6379, State: "online", Offset: 100},
+	}
+	lead(t, c)
+
+	err := c.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fv.Role() != RoleReplica || fv.primaryHost != "10.0.0.3" {
+		t.Errorf("expected replica of 10.0.0.3; got %s of %s", fv.Role(), fv.primaryHost)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != RoleReplica {
+		t.Errorf("role label: expected %s; got %q", RoleReplica, got)
+	}
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if got := ptr.From(lease.Spec.HolderIdentity); got != "valkey-2" {
+		t.Errorf("lease holder: expected valkey-2; got %q", got)
+	}
+	if got := ptr.From(lease.Spec.LeaseTransitions); got != 4 {
+		t.Errorf("lease transitions: expected 4; got %d", got)
+	}
+}
+
+func TestRunShutdownTransfersLease(t *testing.T) {
+	client := testClientset(
+		testPod("valkey-0", "10.0.0.1", nil),
+		testPod("valkey-1", "10.0.0.2", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	fv := newFakeValkey()
+	fv.replicas = []ReplicaInfo{
+		{IP: "10.0.0.2", Port: 6379, State: "online", Offset: 100},
+	}
+	c := NewController(testConfig("valkey-0", "10.0.0.1"), client, fv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	eventually(t, 5*time.Second, func() bool {
+		return c.State() == StatePrimary
+	})
+
+	err := c.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Ending Run must not release the lease over the transfer.
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if got := ptr.From(lease.Spec.HolderIdentity); got != "valkey-1" {
+		t.Errorf("lease holder: expected valkey-1; got %q", got)
+	}
+}
+
+func TestShutdownReleasesLeaseOnFailedTransfer(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	fv.replicas = []ReplicaInfo{
+		{IP: "10.0.0.2", Port: 6379, State: "online", Offset: 100},
+	}
+	transferErr := errors.New("etcdserver: request timed out")
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lease := action.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease)
+		if ptr.From(lease.Spec.HolderIdentity) == "valkey-1" {
+			return true, nil, transferErr
+		}
+		return false, nil, nil
+	})
+	lead(t, c)
+
+	err := c.Shutdown(context.Background())
+	if !errors.Is(err, transferErr) {
+		t.Fatalf("expected the transfer error; got %v", err)
+	}
+	if c.keepLease.Load() {
+		t.Errorf("expected releases to be allowed again")
+	}
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if got := ptr.From(lease.Spec.HolderIdentity); got != "" {
+		t.Errorf("lease holder: expected the lease to be released; got %q", got)
+	}
+}
+
+func TestShutdownWaitsForPromotion(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", nil),
+		testPod("valkey-1", "10.0.0.2", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	setLeaseHolder(t, client, "valkey-0")
+	fv.role = RoleReplica
+	fv.replicas = []ReplicaInfo{
+		{IP: "10.0.0.2", Port: 6379, State: "online", Offset: 100},
+	}
+	fv.block = make(chan struct{})
+	go c.runLoop(t.Context())
+	c.state.fire(TriggerReady)
+	c.OnStartedLeading(t.Context())
+	eventually(t, time.Second, func() bool {
+		return c.loopWatch.busyFor(time.Now()) != 0
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- c.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected Shutdown to wait for the promotion; got %v", err)
+	case <-time.After(5 * switchoverPollInterval):
+	}
+
+	close(fv.block)
+	err := <-done
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fv.mu.Lock()
+	calls := slices.Clone(fv.calls)
+	fv.mu.Unlock()
+	promoted := slices.Index(calls, "REPLICAOF NO ONE")
+	failover := slices.Index(calls, "FAILOVER")
+	if promoted == -1 || failover < promoted {
+		t.Errorf("expected FAILOVER after the promotion; got %v", calls)
+	}
+	if got := podLabel(t, client, "valkey-0", LabelInstanceRole); got != RoleReplica {
+		t.Errorf("role label: expected %s; got %q", RoleReplica, got)
+	}
+}
+
+func TestSwitchoverDNS(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+		testPod("valkey-1", "10.0.0.2", map[string]string{LabelInstanceRole: RoleReplica}),
+	)
+	c.config.ReplicationAddressMode = ReplicationAddressDNS
+	fv.replicas = []ReplicaInfo{
+		{IP: "valkey-1.valkey-headless.default.svc", Port: 6379, State: "online", Offset: 100},
+	}
+	lead(t, c)
+
+	err := c.Switchover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fv.primaryHost != "valkey-1.valkey-headless.default.svc" {
+		t.Errorf("expected failover to valkey-1 by name; got %s", fv.primaryHost)
+	}
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if got := ptr.From(lease.Spec.HolderIdentity); got != "valkey-1" {
+		t.Errorf("lease holder: expected valkey-1; got %q", got)
+	}
+}
+
+func TestShutdownNoTarget(t *testing.T) {
+	c, client, fv := testController(t, "valkey-0", "10.0.0.1",
+		testPod("valkey-0", "10.0.0.1", map[string]string{LabelInstanceRole: RolePrimary}),
+	)
+	lead(t, c)
+
+	err := c.Shutdown(context.Background())
+	if !errors.Is(err, ErrNoSwitchoverTarget) {
+		t.Fatalf("expected ErrNoSwitchoverTarget; got %v", err)
+	}
+	if len(fv.calls) != 0 {
+		t.Errorf("expected no Valkey commands; got %v", fv.calls)
+	}
+	lease, err := client.CoordinationV1().Leases("default").Get(context.Background(), "valkey", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	if got := ptr.From(lease.Spec.HolderIdentity); got != "valkey-0" {
+		t.Errorf("lease holder: expected valkey-0; got %q", got)
+	}
+}
+
+func TestShutdownReplica(t *testing.T) {
+	c, _, fv := testController(t, "valkey-1", "10.0.0.2", testPod("valkey-1", "10.0.0.2", nil))
+
+	err := c.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fv.calls) != 0 {
+		t.Errorf("expected no Valkey commands; got %v", fv.calls)
+	}
+	if c.State() != StateDraining {
+		t.Errorf("expected controller to be draining")
+	}
+}
@@ -0,0 +1,197 @@
+package leader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSFiles locates the PEM files used to talk to Valkey over TLS. Files are
+// re-read whenever their modification time changes, so a rotated Secret
+// mounted into the pod is picked up without a restart.
+type TLSFiles struct {
+	// CAFile verifies the server certificate. If empty, the system roots are
+	// used.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate presented to Valkey.
+	// Both or neither must be set.
+	CertFile string
+	KeyFile  string
+	// ServerName is the name the server certificate is verified against. If
+	// empty, the host being dialed is used.
+	ServerName string
+}
+
+// TLSConfigs returns the tls.Config used to connect to the Valkey at host.
+type TLSConfigs func(host string) *tls.Config
+
+// NewTLSConfigs returns TLSConfigs whose configurations load the certificates
+// in files on every handshake, reusing the parsed files until they change on
+// disk. The server certificate is verified against files.ServerName or, if it
+// is empty, the host being connected to, which may be an IP address.
+func NewTLSConfigs(files TLSFiles) (TLSConfigs, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("TLS client certificate and key must be set together")
+	}
+
+	reloader := &tlsReloader{
+		files: files,
+	}
+	// Load everything once up front so that misconfiguration fails at startup
+	// rather than on the first connection.
+	_, err := reloader.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+	_, err = reloader.clientCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(host string) *tls.Config {
+		serverName := files.ServerName
+		if serverName == "" {
+			serverName = host
+		}
+		return &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: files.ServerName,
+			// Verification is done in VerifyConnection against the current CA
+			// bundle, since RootCAs can't be swapped after the config is in
+			// use.
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				return reloader.verifyConnection(state, serverName)
+			},
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return reloader.clientCertificate()
+			},
+		}
+	}, nil
+}
+
+type tlsReloader struct {
+	files TLSFiles
+
+	mu          sync.Mutex
+	caModTime   time.Time
+	caPool      *x509.CertPool
+	certModTime time.Time
+	keyModTime  time.Time
+	cert        *tls.Certificate
+}
+
+func (r *tlsReloader) rootCAs() (*x509.CertPool, error) {
+	if r.files.CAFile == "" {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := fileModTime(r.files.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.caPool != nil && modTime.Equal(r.caModTime) {
+		return r.caPool, nil
+	}
+
+	pem, err := os.ReadFile(r.files.CAFile)
+	if err != nil {
+		return r.previousCAs(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return r.previousCAs(fmt.Errorf("no certificates found in %s", r.files.CAFile))
+	}
+	r.caPool = pool
+	r.caModTime = modTime
+	return pool, nil
+}
+
+// previousCAs keeps the last good CA bundle in use if the file can't be read
+// mid-rotation.
+func (r *tlsReloader) previousCAs(err error) (*x509.CertPool, error) {
+	if r.caPool != nil {
+		return r.caPool, nil
+	}
+	return nil, err
+}
+
+func (r *tlsReloader) clientCertificate() (*tls.Certificate, error) {
+	if r.files.CertFile == "" {
+		// An empty certificate tells the server we have none to offer.
+		return &tls.Certificate{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certModTime, err := fileModTime(r.files.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyModTime, err := fileModTime(r.files.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if r.cert != nil && certModTime.Equal(r.certModTime) && keyModTime.Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		// The certificate and key are usually rotated together but may be
+		// observed mid-update; keep using the previous pair until both match.
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert = &cert
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	return r.cert, nil
+}
+
+// verifyConnection verifies the server certificate against serverName, a DNS
+// name or IP address.
+func (r *tlsReloader) verifyConnection(state tls.ConnectionState, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	roots, err := r.rootCAs()
+	if err != nil {
+		return err
+	}
+	if serverName == "" {
+		// Verifying without a name would accept any certificate signed by the
+		// CA.
+		return errors.New("no TLS server name to verify the certificate against")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+func fileModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,199 @@
+package leader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	err := os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatalf("failed to set modification time of %s: %v", path, err)
+	}
+}
+
+// handshake dials a TLS server presenting cert and returns the client's
+// handshake error.
+func handshake(t *testing.T, config *tls.Config, cert tls.Certificate) error {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	client := tls.Client(conn, config)
+	defer client.Close()
+	return client.Handshake()
+}
+
+func TestTLSConfigReloadsCA(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	first := newTestCA(t)
+	second := newTestCA(t)
+	writeFile(t, caFile, first.pem, time.Now().Add(-time.Minute))
+
+	configs, err := NewTLSConfigs(TLSFiles{
+		CAFile:     caFile,
+		ServerName: "valkey",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config := configs("127.0.0.1")
+
+	err = handshake(t, config, first.issue(t, "valkey"))
+	if err != nil {
+		t.Fatalf("expected handshake with the first CA to succeed: %v", err)
+	}
+	err = handshake(t, config, first.issue(t, "other"))
+	if err == nil {
+		t.Errorf("expected handshake with the wrong server name to fail")
+	}
+
+	writeFile(t, caFile, second.pem, time.Now())
+	err = handshake(t, config, first.issue(t, "valkey"))
+	if err == nil {
+		t.Errorf("expected handshake with the rotated-out CA to fail")
+	}
+	err = handshake(t, config, second.issue(t, "valkey"))
+	if err != nil {
+		t.Errorf("expected handshake with the rotated CA to succeed: %v", err)
+	}
+}
+
+func TestTLSConfigVerifiesHost(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := newTestCA(t)
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	configs, err := NewTLSConfigs(TLSFiles{
+		CAFile: caFile,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// No SNI is sent when dialing an IP address, so the certificate is
+	// verified against the address itself.
+	err = handshake(t, configs("127.0.0.1"), ca.issue(t, "127.0.0.1"))
+	if err != nil {
+		t.Errorf("expected handshake with a certificate for the IP address to succeed: %v", err)
+	}
+	err = handshake(t, configs("127.0.0.1"), ca.issue(t, "10.0.0.1"))
+	if err == nil {
+		t.Errorf("expected handshake with a certificate for another IP address to fail")
+	}
+	err = handshake(t, configs("valkey"), ca.issue(t, "valkey"))
+	if err != nil {
+		t.Errorf("expected handshake with a certificate for the host name to succeed: %v", err)
+	}
+}
+
+func TestTLSConfigRequiresKeyPair(t *testing.T) {
+	_, err := NewTLSConfigs(TLSFiles{
+		CertFile: "tls.crt",
+	})
+	if err == nil {
+		t.Fatalf("expected error for a certificate without a key")
+	}
+}
@@ -0,0 +1,562 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// Valkey is the subset of Valkey operations the Controller needs to manage
+// replication on the local instance.
+type Valkey interface {
+	Ping(ctx context.Context) error
+	ReplicaOf(ctx context.Context, host string, port int64) error
+	PromoteToPrimary(ctx context.Context) error
+	ReplicationInfo(ctx context.Context) (ReplicationInfo, error)
+	// DBSize returns the number of keys in the selected database.
+	DBSize(ctx context.Context) (int64, error)
+	// Loading reports whether Valkey is still loading its dataset from disk.
+	Loading(ctx context.Context) (bool, error)
+	Failover(ctx context.Context, host string, port int64, timeout time.Duration) error
+	PauseWrites(ctx context.Context, timeout time.Duration) error
+	UnpauseWrites(ctx context.Context) error
+	// CheckPrimaryAuth logs in to the primary at host:port with the
+	// replication credentials and returns ErrReplicationAuth if they are
+	// rejected.
+	CheckPrimaryAuth(ctx context.Context, host string, port int64) error
+	// AnnounceReplicaAddress sets the address the local Valkey reports to its
+	// primary when it is a replica.
+	AnnounceReplicaAddress(ctx context.Context, host string, port int64) error
+}
+
+var ErrReplicationAuth = errors.New("primary rejected the replication credentials")
+
+// ReplicationInfo is the parsed "replication" section of INFO.
+type ReplicationInfo struct {
+	// Role is either "master" or "slave".
+	Role                string
+	MasterReplOffset    int64
+	MasterFailoverState string
+	// MasterLinkStatus is "up" once a replica is connected to its primary.
+	MasterLinkStatus string
+	// MasterSyncInProgress is set while a replica is receiving a full sync
+	// from its primary.
+	MasterSyncInProgress bool
+	Replicas             []ReplicaInfo
+}
+
+// ReplicaInfo describes a replica connected to a primary, as reported by the
+// "slaveN" fields of INFO replication.
+type ReplicaInfo struct {
+	IP     string
+	Port   int64
+	State  string
+	Offset int64
+	Lag    int64
+}
+
+// ValkeyClient implements Valkey on top of a single long-lived valkey-go
+// client. The client is dialed lazily and replaced after a connection error;
+// failed dials are retried with exponential backoff and jitter, and calls made
+// while backing off fail fast with ErrValkeyUnavailable. Before the local
+// Valkey is made a replica it is given the ReplicationCredentials as
+// masteruser/masterauth and, if Option has a TLSConfig, told to use TLS for
+// its replication link.
+type ValkeyClient struct {
+	Option valkey.ClientOption
+	// Credentials, if set, replace Option.Username and Option.Password. They
+	// are loaded before every connection, and the previous credentials are
+	// tried if the current ones are rejected.
+	Credentials CredentialsFunc
+	// Logger reports connection state changes and credential rotations. If
+	// nil, slog.Default is used.
+	Logger *slog.Logger
+	// ReplicationCredentials are used by the local Valkey to authenticate to
+	// its primary. If nil, masteruser and masterauth are left alone.
+	ReplicationCredentials CredentialsFunc
+	// CommandTimeout bounds every operation. If zero, operations are only
+	// bounded by their context.
+	CommandTimeout time.Duration
+	// MinReconnectBackoff and MaxReconnectBackoff bound the delay between
+	// failed connection attempts. They default to DefaultMinReconnectBackoff
+	// and DefaultMaxReconnectBackoff.
+	MinReconnectBackoff time.Duration
+	MaxReconnectBackoff time.Duration
+	// TLSConfigs, if set, provides the TLS configuration for connections to
+	// other Valkey servers, so that their certificates are verified against
+	// their own address rather than that of the local Valkey.
+	TLSConfigs TLSConfigs
+
+	rotation credentialRotation
+
+	mu       sync.Mutex
+	client   valkey.Client
+	failures int
+	lastErr  error
+	nextDial time.Time
+}
+
+const (
+	DefaultMinReconnectBackoff = 500 * time.Millisecond
+	DefaultMaxReconnectBackoff = 30 * time.Second
+)
+
+// ErrValkeyUnavailable is returned while the local Valkey cannot be reached.
+var ErrValkeyUnavailable = errors.New("valkey is unavailable")
+
+func NewValkeyClient(option valkey.ClientOption) *ValkeyClient {
+	return &ValkeyClient{
+		Option: option,
+	}
+}
+
+// withClient runs f with the shared client and a context bounded by
+// CommandTimeout. A connection error from f drops the client so that the next
+// call reconnects.
+func (vc *ValkeyClient) withClient(ctx context.Context, f func(ctx context.Context, client valkey.Client) error) error {
+	client, err := vc.connect()
+	if err != nil {
+		return err
+	}
+
+	callCtx := ctx
+	if vc.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, vc.CommandTimeout)
+		defer cancel()
+	}
+	err = f(callCtx, client)
+	if ctx.Err() == nil && isConnectionError(err) {
+		vc.mu.Lock()
+		if vc.client == client {
+			vc.connectionFailed(err)
+		}
+		vc.mu.Unlock()
+	}
+	return err
+}
+
+// connect returns the shared client, dialing it if there is none and the
+// reconnect backoff has elapsed.
+func (vc *ValkeyClient) connect() (valkey.Client, error) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if vc.client != nil {
+		return vc.client, nil
+	}
+	if time.Now().Before(vc.nextDial) {
+		return nil, fmt.Errorf("%w: %w", ErrValkeyUnavailable, vc.lastErr)
+	}
+	client, err := vc.dial()
+	if err != nil {
+		vc.connectionFailed(err)
+		return nil, fmt.Errorf("%w: %w", ErrValkeyUnavailable, err)
+	}
+	if vc.failures > 0 {
+		vc.logger().Info("reconnected to Valkey", slog.Int("failed_attempts", vc.failures))
+	}
+	vc.client = client
+	vc.failures = 0
+	vc.lastErr = nil
+	return client, nil
+}
+
+// connectionFailed closes the shared client and schedules the next dial. The
+// transition to unavailable is logged once rather than on every attempt. vc.mu
+// must be held.
+func (vc *ValkeyClient) connectionFailed(err error) {
+	if vc.client != nil {
+		vc.client.Close()
+		vc.client = nil
+	}
+	vc.failures++
+	vc.lastErr = err
+	delay := reconnectBackoff(vc.failures, vc.MinReconnectBackoff, vc.MaxReconnectBackoff)
+	vc.nextDial = time.Now().Add(delay)
+	if vc.failures == 1 {
+		vc.logger().Warn("Valkey is unavailable", slog.Any("error", err))
+	} else {
+		vc.logger().Debug("Valkey is still unavailable", slog.Any("error", err), slog.Int("failed_attempts", vc.failures), slog.Duration("retry_in", delay))
+	}
+}
+
+// reconnectBackoff returns the delay after the given number of consecutive
+// failures: minDelay doubled per failure up to maxDelay, with the upper half
+// jittered so that sidecars restarted together do not reconnect in lockstep.
+func reconnectBackoff(failures int, minDelay time.Duration, maxDelay time.Duration) time.Duration {
+	if minDelay <= 0 {
+		minDelay = DefaultMinReconnectBackoff
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxReconnectBackoff
+	}
+	delay := minDelay
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// isConnectionError reports whether err means the connection to Valkey is
+// broken, as opposed to Valkey answering with an error.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := valkey.IsValkeyErr(err); ok {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, valkey.ErrClosing)
+}
+
+// Available reports whether the last attempt to reach Valkey succeeded.
+func (vc *ValkeyClient) Available() bool {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	return vc.failures == 0
+}
+
+// RunHealthChecks pings Valkey every interval until ctx is canceled, so that
+// a lost connection is noticed and retried even between reconciles. When the
+// Credentials change, the client is dropped so that the next call logs in
+// with the new ones.
+func (vc *ValkeyClient) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+		if vc.Credentials != nil {
+			_, changed, err := vc.rotation.candidates(vc.Credentials)
+			if err == nil && changed {
+				vc.logger().Info("Valkey credentials changed, reconnecting")
+				vc.Close()
+			}
+		}
+		_ = vc.Ping(ctx)
+	}
+}
+
+// Close closes the shared client. The next call dials a new one.
+func (vc *ValkeyClient) Close() {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if vc.client != nil {
+		vc.client.Close()
+		vc.client = nil
+	}
+}
+
+func (vc *ValkeyClient) dial() (valkey.Client, error) {
+	if vc.Credentials == nil {
+		return valkey.NewClient(vc.Option)
+	}
+
+	candidates, changed, err := vc.rotation.candidates(vc.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Valkey credentials: %w", err)
+	}
+	if changed {
+		vc.logger().Info("loaded rotated Valkey credentials")
+	}
+
+	for i, credentials := range candidates {
+		option := vc.Option
+		option.Username = credentials.Username
+		option.Password = credentials.Password
+		client, dialErr := valkey.NewClient(option)
+		if dialErr == nil {
+			if i > 0 {
+				vc.logger().Warn("current Valkey credentials were rejected, connected with the previous ones")
+			}
+			return client, nil
+		}
+		if !isAuthError(dialErr) {
+			return nil, dialErr
+		}
+		err = dialErr
+	}
+	return nil, err
+}
+
+func (vc *ValkeyClient) logger() *slog.Logger {
+	if vc.Logger == nil {
+		return slog.Default()
+	}
+	return vc.Logger
+}
+
+func (vc *ValkeyClient) Ping(ctx context.Context) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		return client.Do(ctx, client.B().Ping().Build()).Error()
+	})
+}
+
+func (vc *ValkeyClient) ReplicaOf(ctx context.Context, host string, port int64) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		err := vc.configureReplication(ctx, client)
+		if err != nil {
+			return err
+		}
+		return client.Do(ctx, client.B().Replicaof().Host(host).Port(port).Build()).Error()
+	})
+}
+
+// configureReplication prepares the local Valkey to replicate from a
+// primary: it sets masteruser and masterauth from the ReplicationCredentials
+// and enables tls-replication when the sidecar itself talks to Valkey over
+// TLS, so that the replication link is encrypted too.
+func (vc *ValkeyClient) configureReplication(ctx context.Context, client valkey.Client) error {
+	if vc.ReplicationCredentials != nil {
+		credentials, err := vc.ReplicationCredentials()
+		if err != nil {
+			return fmt.Errorf("error loading replication credentials: %w", err)
+		}
+		if credentials.Username != "" {
+			err = client.Do(ctx, client.B().ConfigSet().ParameterValue().ParameterValue("masteruser", credentials.Username).Build()).Error()
+			if err != nil {
+				return err
+			}
+		}
+		if credentials.Password != "" {
+			err = client.Do(ctx, client.B().ConfigSet().ParameterValue().ParameterValue("masterauth", credentials.Password).Build()).Error()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if vc.Option.TLSConfig != nil {
+		return client.Do(ctx, client.B().ConfigSet().ParameterValue().ParameterValue("tls-replication", "yes").Build()).Error()
+	}
+	return nil
+}
+
+func (vc *ValkeyClient) PromoteToPrimary(ctx context.Context) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		return client.Do(ctx, client.B().Replicaof().No().One().Build()).Error()
+	})
+}
+
+func (vc *ValkeyClient) Info(ctx context.Context, section string) (map[string]string, error) {
+	var raw string
+	err := vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		var err error
+		raw, err = client.Do(ctx, client.B().Info().Section(section).Build()).ToString()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ParseInfo(raw), nil
+}
+
+func (vc *ValkeyClient) ReplicationInfo(ctx context.Context) (ReplicationInfo, error) {
+	info, err := vc.Info(ctx, "replication")
+	if err != nil {
+		return ReplicationInfo{}, err
+	}
+	return ParseReplicationInfo(info)
+}
+
+func (vc *ValkeyClient) DBSize(ctx context.Context) (int64, error) {
+	var size int64
+	err := vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		var err error
+		size, err = client.Do(ctx, client.B().Dbsize().Build()).AsInt64()
+		return err
+	})
+	return size, err
+}
+
+func (vc *ValkeyClient) Loading(ctx context.Context) (bool, error) {
+	info, err := vc.Info(ctx, "persistence")
+	if err != nil {
+		return false, err
+	}
+	loading, err := infoInt64(info, "loading")
+	if err != nil {
+		return false, err
+	}
+	return loading != 0, nil
+}
+
+func (vc *ValkeyClient) Failover(ctx context.Context, host string, port int64, timeout time.Duration) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		// The primary becomes a replica of host once the failover completes.
+		err := vc.configureReplication(ctx, client)
+		if err != nil {
+			return err
+		}
+		return client.Do(ctx, client.B().Failover().To().Host(host).Port(port).Timeout(timeout.Milliseconds()).Build()).Error()
+	})
+}
+
+func (vc *ValkeyClient) PauseWrites(ctx context.Context, timeout time.Duration) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		return client.Do(ctx, client.B().ClientPause().Timeout(timeout.Milliseconds()).Write().Build()).Error()
+	})
+}
+
+func (vc *ValkeyClient) UnpauseWrites(ctx context.Context) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		return client.Do(ctx, client.B().ClientUnpause().Build()).Error()
+	})
+}
+
+func (vc *ValkeyClient) AnnounceReplicaAddress(ctx context.Context, host string, port int64) error {
+	return vc.withClient(ctx, func(ctx context.Context, client valkey.Client) error {
+		return client.Do(ctx, client.B().ConfigSet().ParameterValue().
+			ParameterValue("replica-announce-ip", host).
+			ParameterValue("replica-announce-port", strconv.FormatInt(port, 10)).
+			Build()).Error()
+	})
+}
+
+func (vc *ValkeyClient) CheckPrimaryAuth(ctx context.Context, host string, port int64) error {
+	option := vc.Option
+	option.InitAddress = []string{net.JoinHostPort(host, strconv.FormatInt(port, 10))}
+	option.ForceSingleClient = true
+	option.DisableCache = true
+	if vc.TLSConfigs != nil {
+		option.TLSConfig = vc.TLSConfigs(host)
+	}
+	if vc.ReplicationCredentials != nil {
+		credentials, err := vc.ReplicationCredentials()
+		if err != nil {
+			return fmt.Errorf("error loading replication credentials: %w", err)
+		}
+		option.Username = credentials.Username
+		option.Password = credentials.Password
+	}
+
+	primary := &ValkeyClient{Option: option}
+	defer primary.Close()
+	err := primary.Ping(ctx)
+	if isAuthError(err) {
+		return fmt.Errorf("%w: %w", ErrReplicationAuth, err)
+	}
+	return err
+}
+
+// isAuthError reports whether err is Valkey refusing a login or a command
+// for lack of permissions.
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	for _, prefix := range []string{"WRONGPASS", "NOAUTH", "NOPERM"} {
+		if strings.Contains(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseReplicationInfo converts the fields of INFO replication into a
+// ReplicationInfo.
+func ParseReplicationInfo(info map[string]string) (ReplicationInfo, error) {
+	var err error
+	replication := ReplicationInfo{
+		Role:                info["role"],
+		MasterFailoverState: info["master_failover_state"],
+		MasterLinkStatus:    info["master_link_status"],
+		// Only replicas report master_sync_in_progress.
+		MasterSyncInProgress: info["master_sync_in_progress"] == "1",
+	}
+	replication.MasterReplOffset, err = infoInt64(info, "master_repl_offset")
+	if err != nil {
+		return replication, err
+	}
+	connectedReplicas, err := infoInt64(info, "connected_slaves")
+	if err != nil && replication.Role == "master" {
+		return replication, err
+	}
+	for i := range connectedReplicas {
+		raw, found := info["slave"+strconv.FormatInt(i, 10)]
+		if !found {
+			continue
+		}
+		replica, err := parseReplicaInfo(raw)
+		if err != nil {
+			return replication, err
+		}
+		replication.Replicas = append(replication.Replicas, replica)
+	}
+	return replication, nil
+}
+
+// parseReplicaInfo parses a value such as
+// "ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0".
+func parseReplicaInfo(raw string) (ReplicaInfo, error) {
+	var replica ReplicaInfo
+	fields := map[string]string{}
+	for _, field := range strings.Split(raw, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		fields[key] = value
+	}
+	replica.IP = fields["ip"]
+	replica.State = fields["state"]
+	var err error
+	replica.Port, err = infoInt64(fields, "port")
+	if err != nil {
+		return replica, err
+	}
+	replica.Offset, err = infoInt64(fields, "offset")
+	if err != nil {
+		return replica, err
+	}
+	replica.Lag, err = infoInt64(fields, "lag")
+	if err != nil {
+		return replica, err
+	}
+	return replica, nil
+}
+
+// ParseInfo parses the output of the INFO command into a map of fields.
+// Section headers and blank lines are skipped.
+func ParseInfo(raw string) map[string]string {
+	info := map[string]string{}
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		info[key] = value
+	}
+	return info
+}
+
+func infoInt64(info map[string]string, key string) (int64, error) {
+	raw, found := info[key]
+	if !found {
+		return 0, fmt.Errorf("INFO field not found: %s", key)
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing INFO field %s: %w", key, err)
+	}
+	return value, nil
+}
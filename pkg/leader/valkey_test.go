@@ -0,0 +1,152 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+const testInfoReplication = "# Replication\r\n" +
+	"role:master\r\n" +
+	"connected_slaves:2\r\n" +
+	"slave0:ip=10.0.0.2,port=6379,state=online,offset=1230,lag=0\r\n" +
+	"slave1:ip=10.0.0.3,port=6380,state=wait_bgsave,offset=0,lag=1\r\n" +
+	"master_failover_state:no-failover\r\n" +
+	"master_replid:8c2a5e1f0c4b6f0e1d2e3f4a5b6c7d8e9f0a1b2c\r\n" +
+	"master_repl_offset:1234\r\n"
+
+func TestParseReplicationInfo(t *testing.T) {
+	replication, err := ParseReplicationInfo(ParseInfo(testInfoReplication))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if replication.Role != "master" {
+		t.Errorf("role: expected master; got %q", replication.Role)
+	}
+	if replication.MasterReplOffset != 1234 {
+		t.Errorf("offset: expected 1234; got %d", replication.MasterReplOffset)
+	}
+	if replication.MasterFailoverState != "no-failover" {
+		t.Errorf("failover state: expected no-failover; got %q", replication.MasterFailoverState)
+	}
+	expected := []ReplicaInfo{
+		{IP: "10.0.0.2", Port: 6379, State: "online", Offset: 1230, Lag: 0},
+		{IP: "10.0.0.3", Port: 6380, State: "wait_bgsave", Offset: 0, Lag: 1},
+	}
+	if len(replication.Replicas) != len(expected) {
+		t.Fatalf("replicas: expected %v; got %v", expected, replication.Replicas)
+	}
+	for i := range expected {
+		if replication.Replicas[i] != expected[i] {
+			t.Errorf("replica %d: expected %v; got %v", i, expected[i], replication.Replicas[i])
+		}
+	}
+}
+
+func TestParseReplicationInfoReplica(t *testing.T) {
+	replication, err := ParseReplicationInfo(ParseInfo("role:slave\r\nmaster_host:10.0.0.1\r\nmaster_link_status:down\r\nmaster_sync_in_progress:1\r\nmaster_repl_offset:99\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replication.Role != "slave" || replication.MasterReplOffset != 99 || replication.MasterLinkStatus != "down" || !replication.MasterSyncInProgress || len(replication.Replicas) != 0 {
+		t.Errorf("unexpected replication info: %+v", replication)
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		auth bool
+	}{
+		{nil, false},
+		{errors.New("WRONGPASS invalid username-password pair or user is disabled."), true},
+		{errors.New("NOAUTH Authentication required."), true},
+		{errors.New("dial tcp 10.0.0.1:6379: connect: connection refused"), false},
+	} {
+		if got := isAuthError(tc.err); got != tc.auth {
+			t.Errorf("isAuthError(%v): expected %v; got %v", tc.err, tc.auth, got)
+		}
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	minDelay := time.Second
+	maxDelay := 8 * time.Second
+	tests := []struct {
+		failures int
+		ceiling  time.Duration
+	}{
+		{failures: 1, ceiling: time.Second},
+		{failures: 2, ceiling: 2 * time.Second},
+		{failures: 3, ceiling: 4 * time.Second},
+		{failures: 4, ceiling: 8 * time.Second},
+		{failures: 10, ceiling: 8 * time.Second},
+	}
+	for _, test := range tests {
+		for range 20 {
+			delay := reconnectBackoff(test.failures, minDelay, maxDelay)
+			if delay < test.ceiling/2 || delay > test.ceiling {
+				t.Errorf("failures %d: expected delay in [%s, %s]; got %s", test.failures, test.ceiling/2, test.ceiling, delay)
+			}
+		}
+	}
+}
+
+func TestValkeyClientBacksOffWhileUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	vc := NewValkeyClient(valkey.ClientOption{
+		InitAddress: []string{address},
+	})
+	vc.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	vc.MinReconnectBackoff = time.Hour
+	vc.MaxReconnectBackoff = time.Hour
+	defer vc.Close()
+
+	err = vc.Ping(context.Background())
+	if !errors.Is(err, ErrValkeyUnavailable) {
+		t.Fatalf("expected ErrValkeyUnavailable; got %v", err)
+	}
+	if vc.Available() {
+		t.Errorf("expected client to be unavailable")
+	}
+
+	start := time.Now()
+	err = vc.Ping(context.Background())
+	if !errors.Is(err, ErrValkeyUnavailable) {
+		t.Fatalf("expected ErrValkeyUnavailable while backing off; got %v", err)
+	}
+	if vc.failures != 1 {
+		t.Errorf("expected no dial while backing off; got %d failed attempts", vc.failures)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("expected call to fail fast while backing off")
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	if isConnectionError(nil) {
+		t.Errorf("nil: expected false")
+	}
+	if !isConnectionError(&net.OpError{Op: "dial", Err: errors.New("connection refused")}) {
+		t.Errorf("dial error: expected true")
+	}
+	if !isConnectionError(context.DeadlineExceeded) {
+		t.Errorf("deadline exceeded: expected true")
+	}
+	if isConnectionError(errors.New("error loading replication credentials")) {
+		t.Errorf("other error: expected false")
+	}
+}
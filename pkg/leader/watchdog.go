@@ -0,0 +1,70 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// ErrWatchdogTimeout is the cause Run's context is canceled with when the
+// reconcile loop has not finished an iteration within Config.WatchdogTimeout.
+var ErrWatchdogTimeout = errors.New("reconcile loop stalled")
+
+// loopWatch records since when the reconcile loop has been busy with its
+// current iteration.
+type loopWatch struct {
+	// busySince is the start of the current iteration in Unix nanoseconds, or
+	// 0 while the loop is waiting for the next one.
+	busySince atomic.Int64
+}
+
+func (w *loopWatch) start() {
+	w.busySince.Store(time.Now().UnixNano())
+}
+
+func (w *loopWatch) done() {
+	w.busySince.Store(0)
+}
+
+// busyFor returns how long the current iteration has been running at now, or
+// 0 if the loop is idle.
+func (w *loopWatch) busyFor(now time.Time) time.Duration {
+	since := w.busySince.Load()
+	if since == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, since))
+}
+
+// runWatchdog cancels ctx with ErrWatchdogTimeout once the reconcile loop has
+// spent longer than WatchdogTimeout in a single iteration. A loop
+// stuck on a hung call would otherwise leave the pod holding the lease, or
+// replicating from a stale primary, while the lease keeps being renewed.
+func (c *Controller) runWatchdog(ctx context.Context, cancel context.CancelCauseFunc) {
+	timeout := c.config.WatchdogTimeout
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			busy := c.loopWatch.busyFor(now)
+			if busy <= timeout {
+				continue
+			}
+			state := c.state.current()
+			c.logger.Error(
+				"reconcile loop stalled, releasing the lease and exiting",
+				slog.String("state", string(state)),
+				slog.Duration("busy", busy),
+			)
+			cancel(fmt.Errorf("%w: busy for %s in state %s", ErrWatchdogTimeout, busy.Round(time.Millisecond), state))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
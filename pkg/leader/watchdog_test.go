@@ -0,0 +1,55 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoopWatch(t *testing.T) {
+	var watch loopWatch
+	now := time.Now()
+	if busy := watch.busyFor(now); busy != 0 {
+		t.Errorf("idle loop: expected 0; got %s", busy)
+	}
+
+	watch.busySince.Store(now.Add(-time.Minute).UnixNano())
+	if busy := watch.busyFor(now); busy != time.Minute {
+		t.Errorf("busy loop: expected 1m; got %s", busy)
+	}
+
+	watch.done()
+	if busy := watch.busyFor(now); busy != 0 {
+		t.Errorf("finished loop: expected 0; got %s", busy)
+	}
+}
+
+func TestRunWatchdogReleasesLease(t *testing.T) {
+	client := testClientset(testPod("valkey-0", "10.0.0.1", nil))
+	fv := newFakeValkey()
+	fv.role = RoleReplica
+	fv.block = make(chan struct{})
+	defer close(fv.block)
+	config := testConfig("valkey-0", "10.0.0.1")
+	config.WatchdogTimeout = 200 * time.Millisecond
+	c := NewController(config, client, fv)
+
+	done := make(chan error)
+	go func() {
+		done <- c.Run(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrWatchdogTimeout) {
+			t.Fatalf("expected ErrWatchdogTimeout; got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Run to return after the primary loop stalled")
+	}
+
+	if holder := leaseHolder(t, client); holder != "" {
+		t.Errorf("expected lease to be released; held by %q", holder)
+	}
+}
@@ -24,6 +24,8 @@ const (
 	DefaultRedisPort       = 6379
 	DefaultMetricsPortName = "metrics"
 	DefaultMetricsPort     = 9121
+	DefaultHTTPPortName    = "http"
+	DefaultHTTPPort        = 8080
 
 	DefaultValkeyContainerName        = "valkey"
 	DefaultValkeyLeaderContainerName  = "valkey-leader"
@@ -126,6 +128,21 @@ func CreateService(valkey crd.Valkey, svcType string, serviceConfig crd.ServiceC
 		port = serviceConfig.Port
 	}
 
+	ports := []corev1.ServicePort{
+		{
+			Name:       portName,
+			Port:       int32(port),
+			TargetPort: intstr.FromInt32(port),
+		},
+	}
+	if svcType == DefaultMetricsPortName {
+		ports = append(ports, corev1.ServicePort{
+			Name:       "leader-metrics",
+			Port:       DefaultHTTPPort,
+			TargetPort: intstr.FromString(DefaultHTTPPortName),
+		})
+	}
+
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.Identifier(),
@@ -138,13 +155,7 @@ func CreateService(valkey crd.Valkey, svcType string, serviceConfig crd.ServiceC
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: LabelSelector(valkey, selectors),
-			Ports: []corev1.ServicePort{
-				{
-					Name:       portName,
-					Port:       int32(port),
-					TargetPort: intstr.FromInt32(port),
-				},
-			},
+			Ports:    ports,
 		},
 	}
 }
@@ -238,7 +249,6 @@ func CreateRole(valkey crd.Valkey) *rbacv1.Role {
 					"get",
 					"list",
 					"watch",
-					"update",
 					"patch",
 				},
 			},
@@ -318,6 +328,16 @@ func CreateValkeyContainer(valkey crd.Valkey) corev1.Container {
 	return container
 }
 
+// ValkeyPort returns the port of the Valkey container's "redis" port.
+func ValkeyPort(valkey crd.Valkey) int32 {
+	for _, port := range CreateValkeyContainer(valkey).Ports {
+		if port.Name == DefaultRedisPortName {
+			return port.ContainerPort
+		}
+	}
+	return DefaultRedisPort
+}
+
 func CreateValkeyLeaderContainer(valkey crd.Valkey) corev1.Container {
 	container := corev1.Container{
 		Name: DefaultValkeyLeaderContainerName,
@@ -337,6 +357,50 @@ func CreateValkeyLeaderContainer(valkey crd.Valkey) corev1.Container {
 		)
 	}
 
+	foundPort := false
+	httpPort := int32(DefaultHTTPPort)
+	for i, port := range container.Ports {
+		if port.Name != DefaultHTTPPortName {
+			continue
+		}
+		foundPort = true
+		if port.Protocol == "" {
+			port.Protocol = "TCP"
+		}
+		if port.ContainerPort == 0 {
+			port.ContainerPort = DefaultHTTPPort
+		}
+		httpPort = port.ContainerPort
+		container.Ports[i] = port
+	}
+	if !foundPort {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          DefaultHTTPPortName,
+			ContainerPort: DefaultHTTPPort,
+			Protocol:      "TCP",
+		})
+	}
+	if container.LivenessProbe == nil {
+		container.LivenessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.FromString(DefaultHTTPPortName),
+				},
+			},
+		}
+	}
+	if container.ReadinessProbe == nil {
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/readyz",
+					Port: intstr.FromString(DefaultHTTPPortName),
+				},
+			},
+		}
+	}
+
 	leaseName := valkey.Spec.ValkeyLeader.LeaderLeaseName
 	if leaseName == "" {
 		leaseName = valkey.ObjectMeta.Name
@@ -370,6 +434,14 @@ func CreateValkeyLeaderContainer(valkey crd.Valkey) corev1.Container {
 				},
 			},
 		},
+		{
+			Name: "POD_UID",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "metadata.uid",
+				},
+			},
+		},
 		{
 			Name:  "SERVICE_NAME",
 			Value: ServiceName(valkey, "headless", valkey.Spec.Services.Headless),
@@ -378,6 +450,14 @@ func CreateValkeyLeaderContainer(valkey crd.Valkey) corev1.Container {
 			Name:  "LEADER_LEASE_NAME",
 			Value: leaseName,
 		},
+		{
+			Name:  "HTTP_ADDRESS",
+			Value: fmt.Sprintf(":%d", httpPort),
+		},
+		{
+			Name:  "VALKEY_ADDRESS",
+			Value: fmt.Sprintf("localhost:%d", ValkeyPort(valkey)),
+		},
 	}, container.Env...)
 	return container
 }